@@ -41,25 +41,64 @@ Alto triggers activities like image pulls in the background...
 			fmt.Printf("error getting chart flag: %s\n", err)
 			return
 		}
+		hostNetwork, err := cmd.Flags().GetStringArray("host-network")
+		if err != nil {
+			fmt.Printf("error getting host-network flags: %s\n", err)
+			return
+		}
 		useHostNetwork, err := cmd.Flags().GetBool("useHostNetwork")
 		if err != nil{
 			fmt.Printf("error getting useHostNetwork flag: %s\n", err)
 			return
 		}
+		if useHostNetwork {
+			hostNetwork = append(hostNetwork, "*")
+		}
 		insecureSkipTLSVerify, err := cmd.Flags().GetBool("insecure-skip-tls-verify")
 		if err != nil {
 			fmt.Printf("error getting insecure-skip-tls-verify flag: %s\n", err)
 			return
 		}
+		layout, err := cmd.Flags().GetString("layout")
+		if err != nil {
+			fmt.Printf("error getting layout flag: %s\n", err)
+			return
+		}
+		engine, err := cmd.Flags().GetString("engine")
+		if err != nil {
+			fmt.Printf("error getting engine flag: %s\n", err)
+			return
+		}
+		podInfra, err := cmd.Flags().GetBool("pod-infra")
+		if err != nil {
+			fmt.Printf("error getting pod-infra flag: %s\n", err)
+			return
+		}
+		converterFlags, err := cmd.Flags().GetStringArray("converter")
+		if err != nil {
+			fmt.Printf("error getting converter flags: %s\n", err)
+			return
+		}
+		for _, converterFlag := range converterFlags {
+			apiVersion, kind, converter, err := charts.ParseConverterFlag(converterFlag)
+			if err != nil {
+				fmt.Printf("error parsing converter flag: %s\n", err)
+				return
+			}
+			charts.RegisterConverter(apiVersion, kind, converter)
+		}
 		cUtils, err := charts.NewChartUtils(insecureSkipTLSVerify)
 		if err != nil{
 			fmt.Printf("error initializing chart utils: %s\n", err)
+			return
 		}
-		apps, err := cUtils.Parse(chart, valuesPath, setValues, useHostNetwork)
+		apps, err := cUtils.Parse(chart, valuesPath, setValues, hostNetwork)
 		if err != nil{
+			// writing nothing would replace the release's compose project with an empty one
 			fmt.Printf("error parsing manifest: %v\n", err)
+			return
 		}
-		if err := charts.WriteCompose(apps, charts.ExtractName(chart)); err != nil {
+		if err := charts.WriteCompose(apps, charts.ExtractName(chart), layout, engine, podInfra); err != nil {
 				fmt.Printf("error writing docker compose: %s\n", err)
 		}
 	},
@@ -71,6 +110,12 @@ func init() {
 	syncCmd.Flags().StringP("chart", "c", "chart", "chart repository")
 	syncCmd.Flags().StringP("values", "f", "values", "values path")
 	syncCmd.Flags().Bool("useHostNetwork", false, "whether to use host network or not")
+	syncCmd.Flags().MarkDeprecated("useHostNetwork", "use --host-network '*' instead")
+	syncCmd.Flags().StringArray("host-network", []string{}, "Run a workload on the host network, as <name>, <kind>/<name> or * for all of them (can specify multiple)")
 	syncCmd.Flags().Bool("insecure-skip-tls-verify", false, "skip tls verification for chart pulling")
+	syncCmd.Flags().String("layout", charts.LayoutRelease, "Output layout, release for a single compose project or app for a compose project per app")
+	syncCmd.Flags().StringP("engine", "e", charts.EngineDocker, "Select between docker and podman, restart.sh and run-job.sh use its compose and container commands, pods are Podman pods with podman")
+	syncCmd.Flags().Bool("pod-infra", false, "Group the containers of each pod around an infra container holding the pod's namespaces, with the docker engine")
+	syncCmd.Flags().StringArray("converter", []string{}, "Convert a kind with an external executable, as <apiVersion>/<kind>=<executable> (can specify multiple)")
 	syncCmd.Flags().StringSliceP("set", "s", []string{}, "Set values on the command line (can specify multiple)")
 }
@@ -0,0 +1,397 @@
+package charts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	"go.yaml.in/yaml/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Converter translates the resources of one apiVersion and kind into apps
+type Converter interface {
+	Convert(object runtime.Object, ctx *ConversionContext) ([]spec.App, error)
+}
+
+// ConverterFunc adapts a function into a Converter
+type ConverterFunc func(object runtime.Object, ctx *ConversionContext) ([]spec.App, error)
+
+func (f ConverterFunc) Convert(object runtime.Object, ctx *ConversionContext) ([]spec.App, error) {
+	return f(object, ctx)
+}
+
+// ConversionContext is the release state converters share. Resources pods refer to,
+// ConfigMaps, Secrets, PersistentVolumeClaims and Services, are converted before anything else
+type ConversionContext struct {
+	resources     *releaseResources
+	hostNetwork   map[string]bool   // host network overrides -> whether a workload matched them
+	hostServices  map[string]bool   // Services selecting host networked pods
+	usedPorts     map[int]string    // host port -> host networked app listening on it, for conflict detection
+	appWorkloads  map[string]string // app name -> workload it was generated from, for collision detection
+	hostRewriter  *hostRewriter     // replaces Service addresses with localhost for host networked pods
+	hostResources *releaseResources // resources as host networked pods see them, built on first use
+}
+
+// Release returns the name of the release being converted
+func (ctx *ConversionContext) Release() string {
+	return ctx.resources.release
+}
+
+// AppName returns the service name of a container of a pod, <release>-<pod>-<container> sanitized to a DNS label,
+// the names the built-in converters give their apps
+func (ctx *ConversionContext) AppName(podName string, containerName string) string {
+	return appName(ctx.resources.release, podName, containerName)
+}
+
+// UseHostNetwork reports whether a workload is put on the host network from the command line, by its name,
+// by <kind>/<name> or by * for every workload. Pods setting hostNetwork in their spec run on it regardless
+func (ctx *ConversionContext) UseHostNetwork(kind string, name string) bool {
+	used := false
+	for _, override := range hostNetworkOverrides(kind, name) {
+		if _, exists := ctx.hostNetwork[override]; exists {
+			ctx.hostNetwork[override] = true
+			used = true
+		}
+	}
+	return used
+}
+
+// hostNetworkOverrides returns the overrides putting a workload on the host network
+func hostNetworkOverrides(kind string, name string) []string {
+	return []string{"*", strings.ToLower(name), strings.ToLower(kind + "/" + name)}
+}
+
+// findHostServices records the Services selecting the pods of host networked workloads, before any
+// workload is converted: their addresses become localhost in every host networked pod
+func (ctx *ConversionContext) findHostServices(objects []runtime.Object) {
+	for _, object := range objects {
+		w, err := normalizeWorkload(object)
+		if err != nil || w == nil {
+			continue
+		}
+		hostNetwork := w.template.Spec.HostNetwork
+		for _, override := range hostNetworkOverrides(w.kind, w.name) {
+			if _, exists := ctx.hostNetwork[override]; exists {
+				hostNetwork = true
+			}
+		}
+		if !hostNetwork {
+			continue
+		}
+		for _, serviceInfo := range matchingServices(ctx.resources.services, w.template.Labels) {
+			ctx.hostServices[serviceInfo.Name] = true
+		}
+	}
+}
+
+// ConfigMap returns a ConfigMap of the release by name
+func (ctx *ConversionContext) ConfigMap(name string) (*corev1.ConfigMap, bool) {
+	configMap, exists := ctx.resources.configMaps[name]
+	return configMap, exists
+}
+
+// Secret returns a Secret of the release by name, stringData merged into data
+func (ctx *ConversionContext) Secret(name string) (*corev1.Secret, bool) {
+	secret, exists := ctx.resources.secrets[name]
+	return secret, exists
+}
+
+// Service returns a Service of the release by name
+func (ctx *ConversionContext) Service(name string) (spec.ServiceInfo, bool) {
+	serviceInfo, exists := ctx.resources.services[name]
+	return serviceInfo, exists
+}
+
+// converterKey identifies the resources a converter is registered for
+type converterKey struct {
+	apiVersion string
+	kind       string
+}
+
+var converters = make(map[converterKey]Converter)
+
+// referencedKinds are converted in a first pass, so the converters of workloads can look them up
+var referencedKinds = map[string]bool{
+	"ConfigMap":             true,
+	"Secret":                true,
+	"PersistentVolumeClaim": true,
+	"Service":               true,
+}
+
+// RegisterConverter registers the converter of an apiVersion and kind, replacing the one registered before
+func RegisterConverter(apiVersion string, kind string, converter Converter) {
+	converters[converterKey{apiVersion, kind}] = converter
+}
+
+func init() {
+	RegisterConverter("v1", "ConfigMap", ConverterFunc(convertConfigMap))
+	RegisterConverter("v1", "Secret", ConverterFunc(convertSecret))
+	RegisterConverter("v1", "PersistentVolumeClaim", ConverterFunc(convertPersistentVolumeClaim))
+	RegisterConverter("v1", "Service", ConverterFunc(convertService))
+	RegisterConverter("v1", "Pod", ConverterFunc(convertWorkload))
+	RegisterConverter("apps/v1", "Deployment", ConverterFunc(convertWorkload))
+	RegisterConverter("apps/v1", "StatefulSet", ConverterFunc(convertWorkload))
+	RegisterConverter("apps/v1", "DaemonSet", ConverterFunc(convertWorkload))
+	RegisterConverter("batch/v1", "Job", ConverterFunc(convertWorkload))
+	RegisterConverter("batch/v1", "CronJob", ConverterFunc(convertWorkload))
+}
+
+// convertObject runs the converter registered for an object, nil apps if there is none
+func convertObject(object runtime.Object, ctx *ConversionContext) ([]spec.App, error) {
+	apiVersion, kind := object.GetObjectKind().GroupVersionKind().ToAPIVersionAndKind()
+	converter, exists := converters[converterKey{apiVersion, kind}]
+	if !exists {
+		if workloadKinds[kind] {
+			fmt.Printf("warning: %s %s: apiVersion %s is not supported, skipping\n", kind, objectName(object), apiVersion)
+		}
+		return nil, nil
+	}
+	apps, err := converter.Convert(object, ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx.claimAppNames(apps, fmt.Sprintf("%s %s", kind, objectName(object)))
+	return apps, nil
+}
+
+// objectName returns the metadata.name of an object, empty if it has none
+func objectName(object runtime.Object) string {
+	if named, ok := object.(interface{ GetName() string }); ok {
+		return named.GetName()
+	}
+	return ""
+}
+
+func convertConfigMap(object runtime.Object, ctx *ConversionContext) ([]spec.App, error) {
+	if configMap, ok := object.(*corev1.ConfigMap); ok && configMap.Name != "" {
+		ctx.resources.configMaps[configMap.Name] = configMap
+	}
+	return nil, nil
+}
+
+func convertSecret(object runtime.Object, ctx *ConversionContext) ([]spec.App, error) {
+	if secret, ok := object.(*corev1.Secret); ok && secret.Name != "" {
+		ctx.resources.secrets[secret.Name] = mergeSecretStringData(secret)
+	}
+	return nil, nil
+}
+
+func convertPersistentVolumeClaim(object runtime.Object, ctx *ConversionContext) ([]spec.App, error) {
+	if claim, ok := object.(*corev1.PersistentVolumeClaim); ok && claim.Name != "" {
+		ctx.resources.claims[claim.Name] = claim
+	}
+	return nil, nil
+}
+
+func convertService(object runtime.Object, ctx *ConversionContext) ([]spec.App, error) {
+	service, ok := object.(*corev1.Service)
+	if !ok {
+		return nil, nil
+	}
+	serviceInfo, err := extractServiceInfo(service)
+	if err != nil {
+		return nil, fmt.Errorf("error processing service - %s", err)
+	}
+	ctx.resources.services[service.Name] = *serviceInfo
+	return nil, nil
+}
+
+func convertWorkload(object runtime.Object, ctx *ConversionContext) ([]spec.App, error) {
+	w, err := normalizeWorkload(object)
+	if err != nil || w == nil {
+		return nil, err
+	}
+	if ctx.UseHostNetwork(w.kind, w.name) {
+		w.template.Spec.HostNetwork = true
+	}
+	res := *ctx.resources
+	if w.template.Spec.HostNetwork {
+		res = *ctx.hostNetworkResources()
+		w.template = ctx.hostRewriter.podTemplate(w)
+	}
+	// the downward API refers to the pod by the name its main container ends up with
+	workload := fmt.Sprintf("%s %s", w.kind, w.name)
+	res.claimedName = func(name string) string { return ctx.claimedAppName(name, workload) }
+	apps, err := extractWorkloadApps(w, &res)
+	if err != nil {
+		return nil, err
+	}
+	ctx.claimHostPorts(apps)
+	return apps, nil
+}
+
+// hostNetworkResources returns the release's resources as host networked pods see them. Service names
+// don't resolve on the host network, the addresses of Services reachable from the host become localhost
+func (ctx *ConversionContext) hostNetworkResources() *releaseResources {
+	if ctx.hostResources == nil {
+		ctx.hostRewriter = newHostRewriter(ctx.resources.services, ctx.hostServices)
+		resources := *ctx.resources
+		resources.configMaps = ctx.hostRewriter.configMaps(ctx.resources.configMaps)
+		resources.secrets = ctx.hostRewriter.secrets(ctx.resources.secrets)
+		ctx.hostResources = &resources
+	}
+	return ctx.hostResources
+}
+
+// claimHostPorts reserves the ports host networked apps listen on, the target ports of the Services selecting them.
+// Only one app can listen on a port of the host
+func (ctx *ConversionContext) claimHostPorts(apps []spec.App) {
+	for _, app := range apps {
+		if app.NetworkMode != "host" {
+			continue
+		}
+		var services []string
+		for service := range app.Endpoints {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		for _, service := range services {
+			for _, port := range app.Endpoints[service] {
+				if port == 0 {
+					continue
+				}
+				if owner, taken := ctx.usedPorts[port]; taken && owner != app.Name {
+					fmt.Printf("warning: port conflict: port %d of service %s is already used by %s on the host network, %s cannot listen on it\n", port, service, owner, app.Name)
+					continue
+				}
+				ctx.usedPorts[port] = app.Name
+			}
+		}
+	}
+}
+
+// claimAppNames reserves the names of a workload's apps. Names already taken by another workload,
+// like those of a Deployment and a Job both called app, get the workload's kind and then a number appended
+func (ctx *ConversionContext) claimAppNames(apps []spec.App, workload string) {
+	names := make(map[string]string)
+	for _, app := range apps {
+		renamed := ctx.claimedAppName(app.Name, workload)
+		if renamed == app.Name {
+			continue
+		}
+		fmt.Printf("warning: %s: service %s is already generated by %s, naming it %s\n", workload, app.Name, ctx.appWorkloads[app.Name], renamed)
+		names[app.Name] = renamed
+	}
+	renameApps(apps, names)
+	for _, app := range apps {
+		ctx.appWorkloads[app.Name] = workload
+	}
+}
+
+// claimedAppName returns the name claimAppNames gives an app of a workload
+func (ctx *ConversionContext) claimedAppName(name string, workload string) string {
+	owner, taken := ctx.appWorkloads[name]
+	if !taken || owner == workload {
+		return name
+	}
+	kind, _, _ := strings.Cut(workload, " ")
+	return freeName(name, kind, func(candidate string) bool { return ctx.appWorkloads[candidate] != "" })
+}
+
+// ExecConverter converts resources with an external executable. The resource is written to its stdin
+// as JSON, which is also YAML, and it prints a compose fragment on stdout: the services of the resource,
+// and the named volumes they use. Printing nothing drops the resource, a non-zero exit status fails it.
+// Services are named <release>-<resource>-<service> like the built-in ones, and keep their own name as an alias
+type ExecConverter struct {
+	Path string
+}
+
+func (c ExecConverter) Convert(object runtime.Object, ctx *ConversionContext) ([]spec.App, error) {
+	manifest, err := json.Marshal(object)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding resource for %s: %v", c.Path, err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(c.Path)
+	cmd.Stdin = bytes.NewReader(manifest)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("converter %s failed: %v: %s", c.Path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var fragment spec.ComposeFragment
+	if err := yaml.Unmarshal(stdout.Bytes(), &fragment); err != nil {
+		return nil, fmt.Errorf("converter %s printed an invalid compose fragment: %v", c.Path, err)
+	}
+	_, kind := object.GetObjectKind().GroupVersionKind().ToAPIVersionAndKind()
+	resourceName := objectName(object)
+	var names []string
+	for name := range fragment.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	renamed := make(map[string]string, len(names))
+	for _, name := range names {
+		renamed[name] = ctx.AppName(resourceName, name)
+	}
+	var apps []spec.App
+	for _, name := range names {
+		service := fragment.Services[name]
+		if service == nil {
+			service = make(map[string]interface{})
+		}
+		labels := map[string]string{
+			labelPrefix + "release":  ctx.Release(),
+			labelPrefix + "kind":     kind,
+			labelPrefix + "workload": resourceName,
+		}
+		for key, value := range composeLabels(service["labels"]) {
+			labels[key] = value
+		}
+		service["labels"] = labels
+		image, _ := service["image"].(string)
+		networkMode, _ := service["network_mode"].(string)
+		apps = append(apps, spec.App{
+			Name:           renamed[name],
+			Type:           kind,
+			Image:          image,
+			NetworkMode:    networkMode,
+			Aliases:        []string{name},
+			Labels:         labels,
+			Compose:        service,
+			ComposeVolumes: fragment.Volumes,
+		})
+	}
+	// the fragment's services refer to each other by the names the executable gave them
+	renameApps(apps, renamed)
+	return apps, nil
+}
+
+// composeLabels reads compose labels given either as a map or as a list of key=value
+func composeLabels(value interface{}) map[string]string {
+	labels := make(map[string]string)
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, label := range value {
+			if label != nil {
+				labels[key] = fmt.Sprint(label)
+			} else {
+				labels[key] = ""
+			}
+		}
+	case []interface{}:
+		for _, entry := range value {
+			key, label, _ := strings.Cut(fmt.Sprint(entry), "=")
+			labels[key] = label
+		}
+	}
+	return labels
+}
+
+// ParseConverterFlag parses an external converter given as <apiVersion>/<kind>=<executable>,
+// e.g. monitoring.coreos.com/v1/ServiceMonitor=./servicemonitor-to-compose
+func ParseConverterFlag(value string) (string, string, Converter, error) {
+	target, path, found := strings.Cut(value, "=")
+	slash := strings.LastIndex(target, "/")
+	if !found || slash <= 0 || slash == len(target)-1 || path == "" {
+		return "", "", nil, fmt.Errorf("converter %q is not of the form <apiVersion>/<kind>=<executable>", value)
+	}
+	return target[:slash], target[slash+1:], ExecConverter{Path: path}, nil
+}
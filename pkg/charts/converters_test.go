@@ -0,0 +1,99 @@
+package charts
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	"go.yaml.in/yaml/v3"
+)
+
+func TestExecConverterShortSyntax(t *testing.T) {
+	converter := filepath.Join(t.TempDir(), "exporter-to-compose")
+	script := `#!/bin/sh
+cat >/dev/null
+cat <<'EOF'
+services:
+  exporter:
+    image: exporter:1.0
+    command: exporter --port 9100
+    environment: [A=1]
+    volumes: [data:/data]
+    labels: [team=ops]
+    depends_on: [db]
+  db:
+    image: postgres:16
+  sidecar:
+    image: busybox
+    network_mode: service:exporter
+    depends_on:
+      db:
+        condition: service_started
+volumes:
+  data: {}
+EOF
+`
+	if err := os.WriteFile(converter, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := decodeManifests(`apiVersion: example.com/v1
+kind: Exporter
+metadata:
+  name: metrics
+`)
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("decodeManifests() = %v, %v", objects, err)
+	}
+	ctx := &ConversionContext{resources: &releaseResources{release: "rel"}}
+	apps, err := ExecConverter{Path: converter}.Convert(objects[0], ctx)
+	if err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	appsByName := make(map[string]spec.App)
+	for _, app := range apps {
+		appsByName[app.Name] = app
+	}
+	exporter, sidecar := appsByName["rel-metrics-exporter"], appsByName["rel-metrics-sidecar"]
+	if exporter.Image != "exporter:1.0" || exporter.Labels["team"] != "ops" || exporter.Labels[labelPrefix+"release"] != "rel" {
+		t.Errorf("exporter = %+v", exporter)
+	}
+	if sidecar.NetworkMode != "service:rel-metrics-exporter" {
+		t.Errorf("sidecar network_mode = %q, want service:rel-metrics-exporter", sidecar.NetworkMode)
+	}
+
+	dockerCompose := newDockerCompose("rel")
+	data, err := yaml.Marshal(fragmentService(exporter, "rel", &dockerCompose))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written map[string]interface{}
+	if err := yaml.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written["command"] != "exporter --port 9100" {
+		t.Errorf("command = %v, want the converter's string", written["command"])
+	}
+	if volumes, _ := written["volumes"].([]interface{}); !slices.Equal(volumes, []interface{}{"data:/data"}) {
+		t.Errorf("volumes = %v, want [data:/data]", written["volumes"])
+	}
+	if dependsOn, _ := written["depends_on"].([]interface{}); !slices.Equal(dependsOn, []interface{}{"rel-metrics-db"}) {
+		t.Errorf("depends_on = %v, want [rel-metrics-db]", written["depends_on"])
+	}
+	if !strings.Contains(string(data), "aliases:\n") {
+		t.Errorf("fragment service not joined to the release network:\n%s", data)
+	}
+	if _, exists := dockerCompose.Volumes["data"]; !exists {
+		t.Errorf("volumes = %v, want the fragment's data volume", dockerCompose.Volumes)
+	}
+
+	sidecarData, err := yaml.Marshal(fragmentService(sidecar, "rel", &dockerCompose))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(sidecarData), "rel-metrics-db:\n") || strings.Contains(string(sidecarData), "networks:") {
+		t.Errorf("sidecar fragment =\n%s", sidecarData)
+	}
+}
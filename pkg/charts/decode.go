@@ -0,0 +1,92 @@
+package charts
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// manifestScheme registers the API groups compose translates, decoded into typed k8s.io/api objects
+var manifestScheme = runtime.NewScheme()
+
+var manifestDecoder runtime.Decoder
+
+func init() {
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		corev1.AddToScheme,
+		appsv1.AddToScheme,
+		batchv1.AddToScheme,
+	} {
+		if err := addToScheme(manifestScheme); err != nil {
+			panic(fmt.Sprintf("error registering kubernetes types: %v", err))
+		}
+	}
+	manifestDecoder = serializer.NewCodecFactory(manifestScheme).UniversalDeserializer()
+}
+
+// decodeManifests decodes a multi-document YAML stream, as rendered by Helm, into Kubernetes objects.
+// Kinds of the registered API groups are decoded into their k8s.io/api types, anything else
+// (Ingresses, CRDs, deprecated API versions, ...) into unstructured objects.
+// Documents holding no object, like the "# Source:" comments Helm renders, are skipped
+func decodeManifests(manifest string) ([]runtime.Object, error) {
+	var objects []runtime.Object
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(manifest)))
+	for {
+		document, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return objects, fmt.Errorf("error reading manifest: %v", err)
+		}
+		object, err := decodeManifest(document)
+		if err != nil {
+			fmt.Printf("warning: error decoding resource - %s\n", err)
+			continue
+		}
+		if object != nil {
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+// decodeManifest decodes a single YAML document, nil if it holds no object
+func decodeManifest(document []byte) (runtime.Object, error) {
+	data, err := utilyaml.ToJSON(document)
+	if err != nil {
+		return nil, err
+	}
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(data, &typeMeta); err != nil {
+		// null documents and bare scalars hold no object
+		return nil, nil
+	}
+	if typeMeta.Kind == "" {
+		return nil, nil
+	}
+
+	object, _, err := manifestDecoder.Decode(data, nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		unknown := &unstructured.Unstructured{}
+		if err := unknown.UnmarshalJSON(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", typeMeta.Kind, err)
+		}
+		return unknown, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", typeMeta.Kind, err)
+	}
+	return object, nil
+}
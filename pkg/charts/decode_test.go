@@ -0,0 +1,121 @@
+package charts
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDecodeManifests(t *testing.T) {
+	tests := []struct {
+		name         string
+		manifest     string
+		kinds        []string
+		data         map[string]string // data of the first object, a ConfigMap
+		unstructured bool              // whether the first object is unstructured
+	}{
+		{
+			name: "separator inside a block scalar",
+			manifest: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: front-matter
+data:
+  post.md: |
+    ---
+    title: hello
+    ---
+    body
+`,
+			kinds: []string{"ConfigMap"},
+			data:  map[string]string{"post.md": "---\ntitle: hello\n---\nbody\n"},
+		},
+		{
+			name: "separator inside a quoted value",
+			manifest: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: inline
+data:
+  value: "a\n---\nb"
+`,
+			kinds: []string{"ConfigMap"},
+			data:  map[string]string{"value": "a\n---\nb"},
+		},
+		{
+			name: "stream with helm source comments",
+			manifest: `---
+# Source: chart/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key: value
+---
+# Source: chart/templates/empty.yaml
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: nginx
+`,
+			kinds: []string{"ConfigMap", "Deployment"},
+			data:  map[string]string{"key": "value"},
+		},
+		{
+			name: "unregistered kinds are unstructured",
+			manifest: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+`,
+			kinds:        []string{"Ingress"},
+			unstructured: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects, err := decodeManifests(test.manifest)
+			if err != nil {
+				t.Fatalf("decodeManifests returned %v", err)
+			}
+			if len(objects) != len(test.kinds) {
+				t.Fatalf("decoded %d objects, want %d", len(objects), len(test.kinds))
+			}
+			for i, object := range objects {
+				if kind := object.GetObjectKind().GroupVersionKind().Kind; kind != test.kinds[i] {
+					t.Errorf("object %d is a %s, want a %s", i, kind, test.kinds[i])
+				}
+			}
+			if _, ok := objects[0].(*unstructured.Unstructured); ok != test.unstructured {
+				t.Errorf("decoded a %T, unstructured %v", objects[0], test.unstructured)
+			}
+			if test.data == nil {
+				return
+			}
+			configMap, ok := objects[0].(*corev1.ConfigMap)
+			if !ok {
+				t.Fatalf("decoded a %T, want a ConfigMap", objects[0])
+			}
+			for key, value := range test.data {
+				if configMap.Data[key] != value {
+					t.Errorf("data %s = %q, want %q", key, configMap.Data[key], value)
+				}
+			}
+		})
+	}
+}
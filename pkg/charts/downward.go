@@ -0,0 +1,214 @@
+package charts
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// podIdentity is the pod the downward API describes. There is no scheduler to create one, so it is
+// synthesized: the pod keeps a stable name, the node is the host the release runs on, and the pod IP
+// is a name resolving to the container on the release network
+type podIdentity struct {
+	name               string
+	namespace          string
+	uid                string
+	serviceAccountName string
+	nodeName           string
+	hostIP             string
+	podIP              string
+	labels             map[string]string
+	annotations        map[string]string
+	podSpec            corev1.PodSpec
+}
+
+// newPodIdentity synthesizes the identity of a workload's pod called podName, in the given release
+func newPodIdentity(w *workload, podName string, res *releaseResources) podIdentity {
+	pod := podIdentity{
+		name:        podName,
+		namespace:   w.namespace,
+		labels:      w.template.Labels,
+		annotations: w.template.Annotations,
+		podSpec:     w.template.Spec,
+	}
+	// a name based UUID keeps the uid stable across syncs
+	sum := sha1.Sum([]byte(pod.namespace + "/" + podName))
+	pod.uid = fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+
+	pod.serviceAccountName = w.template.Spec.ServiceAccountName
+	if pod.serviceAccountName == "" {
+		pod.serviceAccountName = w.template.Spec.DeprecatedServiceAccount
+	}
+	if pod.serviceAccountName == "" {
+		pod.serviceAccountName = "default"
+	}
+	pod.nodeName, pod.hostIP = targetHost()
+
+	// pods are reachable through their main container's service
+	pod.podIP = podName
+	if len(w.template.Spec.Containers) > 0 {
+		mainContainer := w.template.Spec.Containers[0].Name
+		if mainContainer == "" {
+			mainContainer = "container-0"
+		}
+		pod.podIP = appName(res.release, podName, mainContainer)
+		if res.claimedName != nil {
+			pod.podIP = res.claimedName(pod.podIP)
+		}
+	}
+	if w.template.Spec.HostNetwork {
+		pod.podIP = pod.hostIP
+	}
+	return pod
+}
+
+// fieldRef resolves a downward API fieldPath, and whether the field is supported
+func (pod podIdentity) fieldRef(fieldPath string) (string, bool) {
+	switch {
+	case fieldPath == "metadata.name":
+		return pod.name, true
+	case fieldPath == "metadata.namespace":
+		return pod.namespace, true
+	case fieldPath == "metadata.uid":
+		return pod.uid, true
+	case fieldPath == "metadata.labels":
+		return formatDownwardAPIMap(pod.labels), true
+	case fieldPath == "metadata.annotations":
+		return formatDownwardAPIMap(pod.annotations), true
+	case strings.HasPrefix(fieldPath, "metadata.labels['") && strings.HasSuffix(fieldPath, "']"):
+		key := strings.TrimSuffix(strings.TrimPrefix(fieldPath, "metadata.labels['"), "']")
+		return pod.labels[key], true
+	case strings.HasPrefix(fieldPath, "metadata.annotations['") && strings.HasSuffix(fieldPath, "']"):
+		key := strings.TrimSuffix(strings.TrimPrefix(fieldPath, "metadata.annotations['"), "']")
+		return pod.annotations[key], true
+	case fieldPath == "spec.nodeName":
+		return pod.nodeName, true
+	case fieldPath == "spec.serviceAccountName":
+		return pod.serviceAccountName, true
+	case fieldPath == "status.hostIP" || fieldPath == "status.hostIPs":
+		return pod.hostIP, true
+	case fieldPath == "status.podIP" || fieldPath == "status.podIPs":
+		return pod.podIP, true
+	}
+	return "", false
+}
+
+// resourceFieldRef resolves a downward API resourceFieldRef against the resources of one of the pod's
+// containers, defaultContainer unless containerName is set. Values are divided by the divisor and
+// rounded up, and unset limits fall back to the capacity of the host, like they do on a node
+func (pod podIdentity) resourceFieldRef(ref *corev1.ResourceFieldSelector, defaultContainer string) (string, error) {
+	containerName := ref.ContainerName
+	if containerName == "" {
+		containerName = defaultContainer
+	}
+	container, found := pod.container(containerName)
+	if !found {
+		return "", fmt.Errorf("container %s not found in the pod", containerName)
+	}
+
+	section, name, found := strings.Cut(ref.Resource, ".")
+	if !found || (section != "limits" && section != "requests") || (name != "cpu" && name != "memory") {
+		return "", fmt.Errorf("resource %s is not supported", ref.Resource)
+	}
+
+	// an unset divisor is 1
+	divisor := ref.Divisor
+	if divisor.IsZero() {
+		divisor = resource.MustParse("1")
+	}
+
+	resourceName := corev1.ResourceName(name)
+	quantity, found := containerQuantity(container, section, resourceName)
+	// requests default to limits, limits to the host's capacity
+	if !found && section == "requests" {
+		quantity, found = containerQuantity(container, "limits", resourceName)
+	}
+	if !found && section == "limits" {
+		quantity = hostCapacity(name)
+	}
+
+	if name == "cpu" {
+		return fmt.Sprintf("%d", ceilDiv(quantity.MilliValue(), divisor.MilliValue())), nil
+	}
+	return fmt.Sprintf("%d", ceilDiv(quantity.Value(), divisor.Value())), nil
+}
+
+// container returns the container or init container of the pod with the given name
+func (pod podIdentity) container(name string) (corev1.Container, bool) {
+	for _, container := range append(append([]corev1.Container{}, pod.podSpec.Containers...), pod.podSpec.InitContainers...) {
+		if container.Name == name {
+			return container, true
+		}
+	}
+	return corev1.Container{}, false
+}
+
+// containerQuantity returns a container's resources.<section>.<name>, and whether it is set
+func containerQuantity(container corev1.Container, section string, name corev1.ResourceName) (resource.Quantity, bool) {
+	quantities := container.Resources.Limits
+	if section == "requests" {
+		quantities = container.Resources.Requests
+	}
+	quantity, exists := quantities[name]
+	return quantity, exists
+}
+
+// ceilDiv divides and rounds up, the way the kubelet converts resources for the downward API
+func ceilDiv(value int64, divisor int64) int64 {
+	quotient := value / divisor
+	if value%divisor != 0 {
+		quotient++
+	}
+	return quotient
+}
+
+// hostCapacity returns the cpu or memory capacity of the host, standing in for the node's allocatable resources
+func hostCapacity(name string) resource.Quantity {
+	if name == "cpu" {
+		return *resource.NewQuantity(int64(runtime.NumCPU()), resource.DecimalSI)
+	}
+	meminfo, err := os.Open("/proc/meminfo")
+	if err == nil {
+		defer meminfo.Close()
+		scanner := bufio.NewScanner(meminfo)
+		for scanner.Scan() {
+			var kilobytes int64
+			if _, err := fmt.Sscanf(scanner.Text(), "MemTotal: %d kB", &kilobytes); err == nil {
+				return *resource.NewQuantity(kilobytes*1024, resource.BinarySI)
+			}
+		}
+	}
+	fmt.Printf("warning: could not read the host's memory capacity\n")
+	return resource.Quantity{}
+}
+
+var (
+	targetHostOnce sync.Once
+	targetHostName string
+	targetHostIP   string
+)
+
+// targetHost returns the name and IP of the host compose runs the release on, standing in for the node
+func targetHost() (string, string) {
+	targetHostOnce.Do(func() {
+		targetHostName, _ = os.Hostname()
+		if targetHostName == "" {
+			targetHostName = "localhost"
+		}
+		targetHostIP = "127.0.0.1"
+		// the source address of the default route, dialing UDP sends nothing
+		if conn, err := net.Dial("udp", "192.0.2.1:9"); err == nil {
+			targetHostIP = conn.LocalAddr().(*net.UDPAddr).IP.String()
+			conn.Close()
+		}
+	})
+	return targetHostName, targetHostIP
+}
@@ -0,0 +1,15 @@
+package charts
+
+// Container engines WriteCompose renders for
+const (
+	EngineDocker = "docker"
+	EnginePodman = "podman"
+)
+
+// engineCommands returns the container and compose commands restart.sh and run-job.sh drive an engine with
+func engineCommands(engine string) (string, string) {
+	if engine == EnginePodman {
+		return "podman", "podman-compose"
+	}
+	return "docker", "docker-compose"
+}
@@ -0,0 +1,65 @@
+package charts
+
+import (
+	"strings"
+)
+
+// expandVars expands $(VAR) references the way the kubelet does for command, args and env values:
+// defined variables are substituted, undefined references are kept verbatim, and $$ escapes a $
+func expandVars(input string, env map[string]string) string {
+	var expanded strings.Builder
+	checkpoint := 0
+	for cursor := 0; cursor < len(input); cursor++ {
+		if input[cursor] != '$' || cursor+1 >= len(input) {
+			continue
+		}
+		expanded.WriteString(input[checkpoint:cursor])
+		read, isVar, advance := readVariableName(input[cursor+1:])
+		if isVar {
+			if value, defined := env[read]; defined {
+				expanded.WriteString(value)
+			} else {
+				expanded.WriteString("$(" + read + ")")
+			}
+		} else {
+			expanded.WriteString(read)
+		}
+		cursor += advance
+		checkpoint = cursor + 1
+	}
+	return expanded.String() + input[checkpoint:]
+}
+
+// readVariableName reads what follows a $: the name of a $(VAR) reference, or the literal text to
+// keep otherwise. It also returns how many characters it consumed
+func readVariableName(input string) (string, bool, int) {
+	switch input[0] {
+	case '$':
+		return "$", false, 1
+	case '(':
+		if end := strings.IndexByte(input, ')'); end != -1 {
+			return input[1:end], true, end + 1
+		}
+		// unterminated reference, kept as is
+		return "$(", false, 1
+	default:
+		return "$" + input[0:1], false, 1
+	}
+}
+
+// escapeInterpolation escapes $ as $$, so docker-compose passes values through instead of interpolating them
+func escapeInterpolation(value string) string {
+	return strings.ReplaceAll(value, "$", "$$")
+}
+
+// escapeInterpolationAll escapes every value of a list, see escapeInterpolation
+func escapeInterpolationAll(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	escaped := make([]string, len(values))
+	for i, value := range values {
+		escaped[i] = escapeInterpolation(value)
+	}
+	return escaped
+}
@@ -0,0 +1,29 @@
+package charts
+
+import "testing"
+
+func TestExpandVars(t *testing.T) {
+	env := map[string]string{"HOST": "db", "PORT": "5432", "EMPTY": ""}
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"no references", "plain value", "plain value"},
+		{"defined", "$(HOST):$(PORT)", "db:5432"},
+		{"defined empty", "[$(EMPTY)]", "[]"},
+		{"undefined kept", "$(MISSING)/data", "$(MISSING)/data"},
+		{"escaped", "$$(HOST)", "$(HOST)"},
+		{"double dollar", "cost $$5", "cost $5"},
+		{"lone dollar", "$HOST and $", "$HOST and $"},
+		{"unterminated", "$(HOST", "$(HOST"},
+		{"adjacent", "$(HOST)$(PORT)", "db5432"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := expandVars(test.input, env); got != test.want {
+				t.Errorf("expandVars(%q) = %q, want %q", test.input, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package charts
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// hookEvents are the Helm hook events restart.sh runs, in the order of a rollout
+var hookEvents = []release.HookEvent{
+	release.HookPreInstall,
+	release.HookPreUpgrade,
+	release.HookPostInstall,
+	release.HookPostUpgrade,
+}
+
+// hookSpecFor returns the hook settings of a rendered Helm hook, nil if none of its events are ever triggered
+func hookSpecFor(hook *release.Hook) *spec.HookSpec {
+	hookSpec := &spec.HookSpec{Weight: hook.Weight}
+	for _, event := range hook.Events {
+		supported := false
+		for _, hookEvent := range hookEvents {
+			if event == hookEvent {
+				supported = true
+				break
+			}
+		}
+		if supported {
+			hookSpec.Events = append(hookSpec.Events, event.String())
+		} else {
+			fmt.Printf("warning: hook %s: %s hooks are not run by compose\n", hook.Name, event)
+		}
+	}
+	if len(hookSpec.Events) == 0 {
+		return nil
+	}
+	for _, policy := range hook.DeletePolicies {
+		hookSpec.DeletePolicies = append(hookSpec.DeletePolicies, policy.String())
+	}
+	// Helm defaults to before-hook-creation when no policy is set
+	if len(hookSpec.DeletePolicies) == 0 {
+		hookSpec.DeletePolicies = []string{release.HookBeforeHookCreation.String()}
+	}
+	return hookSpec
+}
+
+// hasDeletePolicy reports whether a hook app carries the given hook-delete-policy
+func hasDeletePolicy(app spec.App, policy release.HookDeletePolicy) bool {
+	if app.Hook == nil {
+		return false
+	}
+	for _, p := range app.Hook.DeletePolicies {
+		if p == policy.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// sortHooks orders hook apps the way Helm runs them, by weight and then by name
+func sortHooks(hooks []spec.App) {
+	sort.SliceStable(hooks, func(i, j int) bool {
+		if hooks[i].Hook.Weight != hooks[j].Hook.Weight {
+			return hooks[i].Hook.Weight < hooks[j].Hook.Weight
+		}
+		return hooks[i].Name < hooks[j].Name
+	})
+}
@@ -0,0 +1,168 @@
+package charts
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	"helm.sh/helm/v3/pkg/release"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// jobProfile gates Job and CronJob services, so `docker-compose up` leaves them to run-job.sh
+const jobProfile = "jobs"
+
+// jobSpecFor returns the run settings of a Job
+func jobSpecFor(jobSpec batchv1.JobSpec) *spec.JobSpec {
+	job := &spec.JobSpec{BackoffLimit: 6}
+	if jobSpec.BackoffLimit != nil {
+		job.BackoffLimit = int(*jobSpec.BackoffLimit)
+	}
+	if jobSpec.ActiveDeadlineSeconds != nil {
+		job.ActiveDeadlineSeconds = int(*jobSpec.ActiveDeadlineSeconds)
+	}
+	return job
+}
+
+// cronJobSpecFor returns the run settings of a CronJob, those of its job template along with its schedule
+func cronJobSpecFor(cronJob *batchv1.CronJob) (*spec.JobSpec, error) {
+	job := jobSpecFor(cronJob.Spec.JobTemplate.Spec)
+	job.Schedule = cronJob.Spec.Schedule
+	if job.Schedule == "" {
+		return nil, fmt.Errorf("missing spec.schedule")
+	}
+	job.ConcurrencyPolicy = string(cronJob.Spec.ConcurrencyPolicy)
+	if job.ConcurrencyPolicy == "" {
+		job.ConcurrencyPolicy = string(batchv1.AllowConcurrent)
+	}
+	job.Suspend = cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend
+	if timeZone := cronJob.Spec.TimeZone; timeZone != nil && *timeZone != "" {
+		fmt.Printf("warning: cronjob %s: timeZone %s is not supported, the schedule uses the host's time zone\n",
+			cronJob.Name, *timeZone)
+	}
+	return job, nil
+}
+
+// writeJobScripts writes run-job.sh for a Job, CronJob or hook app into jobDir, and the crontab fragment of a CronJob.
+// The job runs from composeFile, and is only run again once specFile, relative to jobDir, changed.
+// It returns the path of the crontab fragment, if any
+func writeJobScripts(app spec.App, jobDir string, composeFile string, specFile string, name string, engine string) (string, error) {
+	// finished containers are removed, unless a hook-delete-policy keeps them around
+	removeSucceeded := app.Hook == nil || hasDeletePolicy(app, release.HookSucceeded)
+	removeFailed := app.Hook == nil || hasDeletePolicy(app, release.HookFailed)
+
+	containerCommand, composeCommand := engineCommands(engine)
+	script := fmt.Sprintf(`#!/bin/bash
+# Runs %[1]s to completion, the way the Kubernetes Job controller would:
+# up to %[2]d retries with exponential backoff, and a deadline of %[3]ds (0 means none)
+cd "$(dirname "$0")"
+
+service=%[1]q
+compose_file=%[6]q
+backoff_limit=%[2]d
+deadline=%[3]d
+remove_succeeded=%[4]t
+remove_failed=%[5]t
+engine=%[7]s
+compose=%[8]s
+`, app.Name, app.Job.BackoffLimit, app.Job.ActiveDeadlineSeconds, removeSucceeded, removeFailed, composeFile, containerCommand, composeCommand)
+
+	if engine == EnginePodman {
+		// podman-compose doesn't label one-off containers, they are found by the names given to them below
+		script += `containers() {
+    $engine ps "$@" -q --filter "name=^$service-[0-9]+-[0-9]+$"
+}
+`
+	} else {
+		script += `containers() {
+    $engine ps "$@" -q --filter label=com.docker.compose.project=$(basename "$(dirname "$compose_file")") \
+        --filter label=com.docker.compose.service=$service --filter label=com.docker.compose.oneoff=True
+}
+`
+	}
+	script += `running() {
+    containers
+}
+`
+
+	if hasDeletePolicy(app, release.HookBeforeHookCreation) {
+		script += `
+# hook-delete-policy: before-hook-creation
+containers -a | xargs -r $engine rm -f
+`
+	}
+
+	switch app.Job.ConcurrencyPolicy {
+	case "Forbid":
+		script += `
+if [ -n "$(running)" ]; then
+    echo "$service is still running, skipping this run (concurrencyPolicy: Forbid)"
+    exit 0
+fi
+`
+	case "Replace":
+		script += `
+if [ -n "$(running)" ]; then
+    echo "replacing the running $service (concurrencyPolicy: Replace)"
+    running | xargs -r $engine rm -f
+fi
+`
+	}
+
+	script += fmt.Sprintf(`
+start=$(date +%%s)
+delay=10
+for attempt in $(seq 1 $((backoff_limit + 1))); do
+    container="$service-$(date +%%s)-$attempt"
+    timeout_cmd=()
+    if [ $deadline -gt 0 ]; then
+        remaining=$((deadline - ($(date +%%s) - start)))
+        if [ $remaining -le 0 ]; then
+            echo "$service exceeded its deadline of ${deadline}s"
+            exit 1
+        fi
+        timeout_cmd=(timeout $remaining)
+    fi
+
+    if "${timeout_cmd[@]}" $compose -f "$compose_file" --profile %[1]s run --name "$container" "$service"; then
+        echo "$service completed successfully"
+        if [ "$remove_succeeded" = true ]; then
+            $engine rm -f "$container" >/dev/null 2>&1 || true
+        fi
+        sha256sum %[2]q > .job-completed
+        exit 0
+    fi
+    $engine stop "$container" >/dev/null 2>&1 || true
+    if [ "$remove_failed" = true ]; then
+        $engine rm -f "$container" >/dev/null 2>&1 || true
+    fi
+
+    echo "$service failed (attempt $attempt)"
+    if [ $attempt -le $backoff_limit ]; then
+        sleep $delay
+        delay=$((delay * 2 > 360 ? 360 : delay * 2))
+    fi
+done
+
+echo "$service reached its backoff limit of $backoff_limit"
+exit 1
+`, jobProfile, specFile)
+
+	if err := os.WriteFile(fmt.Sprintf("%s/run-job.sh", jobDir), []byte(script), 0755); err != nil {
+		return "", fmt.Errorf("error writing job script: %v", err)
+	}
+
+	if app.Job.Schedule == "" {
+		return "", nil
+	}
+	entry := fmt.Sprintf("%s %s/run-job.sh >> %s/job.log 2>&1\n", app.Job.Schedule, jobDir, jobDir)
+	if app.Job.Suspend {
+		entry = "# suspended: " + entry
+	}
+	fragment := fmt.Sprintf("# %s/%s (CronJob)\n%s", name, app.Name, entry)
+	fragmentPath := fmt.Sprintf("%s/%s.cron", jobDir, app.Name)
+	if err := os.WriteFile(fragmentPath, []byte(fragment), 0644); err != nil {
+		return "", fmt.Errorf("error writing crontab fragment: %v", err)
+	}
+	return fragmentPath, nil
+}
@@ -0,0 +1,276 @@
+package charts
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// hostRewrite records a Service address replaced with localhost
+type hostRewrite struct {
+	source string // where the address was found, like configmap/app DB_HOST
+	from   string
+	to     string
+}
+
+// hostRewriter replaces the addresses of a release's Services with localhost. With host networking
+// Service names no longer resolve, the Services of host networked pods listen on the host on their
+// target ports, and NodePort and LoadBalancer Services of bridged pods on the ports they publish
+type hostRewriter struct {
+	services    []serviceAddress
+	rewrites    []hostRewrite
+	unreachable []hostRewrite // addresses of ClusterIP Services of bridged pods, kept as they are
+}
+
+// serviceAddress matches the DNS names of a Service, optionally followed by a port
+type serviceAddress struct {
+	service spec.ServiceInfo
+	pattern *regexp.Regexp
+	onHost  bool // the Service selects host networked pods
+}
+
+// newHostRewriter returns a rewriter for the Services of a release, hostServices being those selecting
+// host networked pods. ExternalName Services point outside of the release and are kept
+func newHostRewriter(services map[string]spec.ServiceInfo, hostServices map[string]bool) *hostRewriter {
+	rewriter := &hostRewriter{}
+	for _, serviceInfo := range services {
+		if serviceInfo.Type == string(corev1.ServiceTypeExternalName) {
+			continue
+		}
+		names := serviceDNSNames(serviceInfo)
+		// the longest name first, alternatives are tried in order
+		sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+		for i, name := range names {
+			names[i] = regexp.QuoteMeta(name)
+		}
+		rewriter.services = append(rewriter.services, serviceAddress{
+			service: serviceInfo,
+			pattern: regexp.MustCompile(`(?:` + strings.Join(names, "|") + `)(?::(\d+))?`),
+			onHost:  hostServices[serviceInfo.Name],
+		})
+	}
+	sort.Slice(rewriter.services, func(i, j int) bool {
+		return rewriter.services[i].service.Name < rewriter.services[j].service.Name
+	})
+	return rewriter
+}
+
+// rewrite replaces the Service addresses in a value, host:port pairs get the port the Service listens on the host
+func (r *hostRewriter) rewrite(source string, value string) string {
+	for _, address := range r.services {
+		var rewritten strings.Builder
+		rest := value
+		for {
+			match := address.pattern.FindStringSubmatchIndex(rest)
+			if match == nil {
+				break
+			}
+			start, end := match[0], match[1]
+			if !hostBoundary(rest, start, end) {
+				rewritten.WriteString(rest[:start+1])
+				rest = rest[start+1:]
+				continue
+			}
+			port := 0
+			if match[2] != -1 {
+				port, _ = strconv.Atoi(rest[match[2]:match[3]])
+			}
+			to, reachable := address.localhost(port)
+			if !reachable {
+				r.unreachable = append(r.unreachable, hostRewrite{source: source, from: rest[start:end]})
+				to = rest[start:end]
+			} else {
+				r.rewrites = append(r.rewrites, hostRewrite{source: source, from: rest[start:end], to: to})
+			}
+			rewritten.WriteString(rest[:start])
+			rewritten.WriteString(to)
+			rest = rest[end:]
+		}
+		rewritten.WriteString(rest)
+		value = rewritten.String()
+	}
+	return value
+}
+
+// localhost returns the address of a Service on the host, for an address naming port, 0 for none.
+// ClusterIP Services of bridged pods publish nothing, and can't be reached from the host network
+func (address serviceAddress) localhost(port int) (string, bool) {
+	switch {
+	case address.onHost:
+		port = targetPortOf(address.service, port)
+	case address.service.Type == string(corev1.ServiceTypeNodePort) || address.service.Type == string(corev1.ServiceTypeLoadBalancer):
+		port = publishedPortOf(address.service, port)
+	default:
+		return "", false
+	}
+	if port == 0 {
+		return "localhost", true
+	}
+	return fmt.Sprintf("localhost:%d", port), true
+}
+
+// hostBoundary reports whether a match stands on its own, and isn't part of a longer host name like my-db or db.example.com
+func hostBoundary(value string, start int, end int) bool {
+	if start > 0 && isHostChar(value[start-1], true) {
+		return false
+	}
+	if end < len(value) {
+		next := value[end]
+		if next == '.' {
+			return end+1 == len(value) || !isHostChar(value[end+1], false)
+		}
+		return !isHostChar(next, false)
+	}
+	return true
+}
+
+// isHostChar reports whether a character can be part of a host name, dots included when asked to
+func isHostChar(c byte, dot bool) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || (dot && c == '.')
+}
+
+// targetPortOf returns the port a Service port forwards to, containers listen on it directly with host networking.
+// Ports the Service doesn't declare, and named targetPorts, are kept as they are
+func targetPortOf(serviceInfo spec.ServiceInfo, port int) int {
+	for _, portInfo := range serviceInfo.Ports {
+		if portInfo.Port == port && portInfo.TargetPort != 0 {
+			return portInfo.TargetPort
+		}
+	}
+	return port
+}
+
+// publishedPortOf returns the host port a NodePort or LoadBalancer Service port is published on, see publishedPorts.
+// Ports the Service doesn't declare are kept as they are
+func publishedPortOf(serviceInfo spec.ServiceInfo, port int) int {
+	for _, portInfo := range serviceInfo.Ports {
+		if portInfo.Port == port {
+			if serviceInfo.Type == string(corev1.ServiceTypeNodePort) && portInfo.NodePort != 0 {
+				return portInfo.NodePort
+			}
+			return port
+		}
+	}
+	return port
+}
+
+// configMaps returns copies of ConfigMaps with the Service addresses in their values rewritten
+func (r *hostRewriter) configMaps(configMaps map[string]*corev1.ConfigMap) map[string]*corev1.ConfigMap {
+	updatedConfigMaps := make(map[string]*corev1.ConfigMap, len(configMaps))
+	for configMapName, configMap := range configMaps {
+		updatedConfigMap := configMap.DeepCopy()
+		for key, value := range updatedConfigMap.Data {
+			updatedConfigMap.Data[key] = r.rewrite(fmt.Sprintf("configmap/%s %s", configMapName, key), value)
+		}
+		updatedConfigMaps[configMapName] = updatedConfigMap
+	}
+	return updatedConfigMaps
+}
+
+// secrets returns copies of Secrets with the Service addresses in their values rewritten. Binary values and
+// PEM encoded keys and certificates are kept as they are
+func (r *hostRewriter) secrets(secrets map[string]*corev1.Secret) map[string]*corev1.Secret {
+	updatedSecrets := make(map[string]*corev1.Secret, len(secrets))
+	for secretName, secret := range secrets {
+		updatedSecret := secret.DeepCopy()
+		for key, value := range updatedSecret.Data {
+			if !utf8.Valid(value) || strings.Contains(string(value), "-----BEGIN ") {
+				continue
+			}
+			updatedSecret.Data[key] = []byte(r.rewrite(fmt.Sprintf("secret/%s %s", secretName, key), string(value)))
+		}
+		updatedSecrets[secretName] = updatedSecret
+	}
+	return updatedSecrets
+}
+
+// podTemplate returns a copy of a workload's pod template with the Service addresses in its containers' literal env
+// values, commands, args and exec hooks rewritten. Values read from ConfigMaps and Secrets are rewritten along with them
+func (r *hostRewriter) podTemplate(w *workload) corev1.PodTemplateSpec {
+	template := w.template.DeepCopy()
+	rewriteContainers := func(containers []corev1.Container) {
+		for i := range containers {
+			container := &containers[i]
+			source := fmt.Sprintf("%s/%s container %s", strings.ToLower(w.kind), w.name, container.Name)
+			for j := range container.Env {
+				container.Env[j].Value = r.rewrite(fmt.Sprintf("%s env %s", source, container.Env[j].Name), container.Env[j].Value)
+			}
+			r.rewriteAll(source+" command", container.Command)
+			r.rewriteAll(source+" args", container.Args)
+			if container.Lifecycle != nil {
+				if handler := container.Lifecycle.PostStart; handler != nil && handler.Exec != nil {
+					r.rewriteAll(source+" postStart", handler.Exec.Command)
+				}
+				if handler := container.Lifecycle.PreStop; handler != nil && handler.Exec != nil {
+					r.rewriteAll(source+" preStop", handler.Exec.Command)
+				}
+			}
+		}
+	}
+	rewriteContainers(template.Spec.InitContainers)
+	rewriteContainers(template.Spec.Containers)
+	return *template
+}
+
+// rewriteAll rewrites a list of values in place
+func (r *hostRewriter) rewriteAll(source string, values []string) {
+	for i := range values {
+		values[i] = r.rewrite(source, values[i])
+	}
+}
+
+// report prints every substitution made, for operators to audit, and warns about the addresses left unreachable
+func (r *hostRewriter) report() {
+	// ConfigMaps and Secrets are rewritten in no particular order
+	sort.SliceStable(r.unreachable, func(i, j int) bool { return r.unreachable[i].source < r.unreachable[j].source })
+	for _, address := range r.unreachable {
+		fmt.Printf("warning: %s: %s is a ClusterIP Service of bridged pods, unreachable from the host network, "+
+			"make it a NodePort Service or put its workload on the host network\n", address.source, address.from)
+	}
+	if len(r.rewrites) == 0 {
+		return
+	}
+	sort.SliceStable(r.rewrites, func(i, j int) bool { return r.rewrites[i].source < r.rewrites[j].source })
+	fmt.Printf("replaced %d Service addresses with localhost for host networking:\n", len(r.rewrites))
+	for _, rewrite := range r.rewrites {
+		fmt.Printf("  %s: %s -> %s\n", rewrite.source, rewrite.from, rewrite.to)
+	}
+}
+
+// hostGatewayHosts points the DNS names of the Services selecting host networked pods at the host, for
+// bridged apps to reach those pods there. They listen on the Service's target ports, Service ports
+// forwarding elsewhere are reported
+func hostGatewayHosts(apps []spec.App, services map[string]spec.ServiceInfo, hostServices map[string]bool) {
+	var extraHosts []string
+	for _, serviceInfo := range services {
+		if !hostServices[serviceInfo.Name] || serviceInfo.Type == string(corev1.ServiceTypeExternalName) {
+			continue
+		}
+		for _, dnsName := range serviceDNSNames(serviceInfo) {
+			extraHosts = append(extraHosts, dnsName+":host-gateway")
+		}
+		for _, portInfo := range serviceInfo.Ports {
+			if portInfo.TargetPort != 0 && portInfo.TargetPort != portInfo.Port {
+				fmt.Printf("warning: service %s: bridged services reach its host networked pods on port %d, they listen on %d\n",
+					serviceInfo.Name, portInfo.Port, portInfo.TargetPort)
+			}
+		}
+	}
+	if len(extraHosts) == 0 {
+		return
+	}
+	sort.Strings(extraHosts)
+	for i := range apps {
+		// host networked apps use the host's own resolver, and fragments are written as they are
+		if apps[i].NetworkMode == "host" || apps[i].Compose != nil {
+			continue
+		}
+		apps[i].ExtraHosts = append(apps[i].ExtraHosts, extraHosts...)
+	}
+}
@@ -0,0 +1,83 @@
+package charts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+)
+
+func TestHostRewriterRewrite(t *testing.T) {
+	services := map[string]spec.ServiceInfo{
+		"db": {Name: "db", Namespace: "data", Type: "ClusterIP", Ports: []spec.PortInfo{
+			{Port: 5432, TargetPort: 15432},
+			{Port: 80, TargetPortName: "http"},
+		}},
+		"cache": {Name: "cache", Namespace: "default", Type: "ClusterIP", Ports: []spec.PortInfo{{Port: 6379, TargetPort: 6379}}},
+		"ext":   {Name: "ext", Namespace: "default", Type: "ExternalName"},
+		"web": {Name: "web", Namespace: "default", Type: "NodePort", Ports: []spec.PortInfo{
+			{Port: 80, TargetPort: 8080, NodePort: 30080},
+			{Port: 443, TargetPort: 8443},
+		}},
+		"queue": {Name: "queue", Namespace: "default", Type: "ClusterIP", Ports: []spec.PortInfo{{Port: 5672, TargetPort: 5672}}},
+	}
+	hostServices := map[string]bool{"db": true, "cache": true}
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"bare name", "db", "localhost"},
+		{"host and port", "db:5432", "localhost:15432"},
+		{"fqdn", "postgres://user@db.data.svc.cluster.local:5432/app", "postgres://user@localhost:15432/app"},
+		{"namespaced", "host=db.data port=5432", "host=localhost port=5432"},
+		{"svc suffix", "db.data.svc", "localhost"},
+		{"undeclared port kept", "db:9999", "localhost:9999"},
+		{"named target port kept", "db:80", "localhost:80"},
+		{"url path", "http://cache/health", "http://localhost/health"},
+		{"sentence end", "connect to cache.", "connect to localhost."},
+		{"several", "cache:6379,db:5432", "localhost:6379,localhost:15432"},
+		{"longer host kept", "my-db db-primary db.example.com", "my-db db-primary db.example.com"},
+		{"other namespace kept", "db.other.svc", "db.other.svc"},
+		{"word inside kept", "dbx xdb", "dbx xdb"},
+		{"external name kept", "ext:443", "ext:443"},
+		{"bridged node port", "http://web:80/", "http://localhost:30080/"},
+		{"bridged published port", "web:443", "localhost:443"},
+		{"bridged cluster ip kept", "amqp://queue:5672", "amqp://queue:5672"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rewriter := newHostRewriter(services, hostServices)
+			if got := rewriter.rewrite("test", test.value); got != test.want {
+				t.Errorf("rewrite(%q) = %q, want %q", test.value, got, test.want)
+			}
+			if unreachable := len(rewriter.unreachable) > 0; unreachable != strings.Contains(test.value, "queue") {
+				t.Errorf("rewrite(%q) reported %d unreachable addresses", test.value, len(rewriter.unreachable))
+			}
+		})
+	}
+}
+
+func TestHostBoundary(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end int
+		want       bool
+	}{
+		{"db", 0, 2, true},
+		{"(db)", 1, 3, true},
+		{"//db/", 2, 4, true},
+		{"my-db", 3, 5, false},
+		{"a.db", 2, 4, false},
+		{"db-1", 0, 2, false},
+		{"db_x", 0, 2, false},
+		{"db.example", 0, 2, false},
+		{"db.", 0, 2, true},
+		{"db. next", 0, 2, true},
+	}
+	for _, test := range tests {
+		if got := hostBoundary(test.value, test.start, test.end); got != test.want {
+			t.Errorf("hostBoundary(%q, %d, %d) = %v, want %v", test.value, test.start, test.end, got, test.want)
+		}
+	}
+}
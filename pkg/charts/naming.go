@@ -0,0 +1,120 @@
+package charts
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+)
+
+// maxNameLength is the length of a DNS label, compose service names double as host names on the release network
+const maxNameLength = 63
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// appName returns the compose service name of a container of a pod, <release>-<pod>-<container>.
+// The release prefix is left out when the pod already starts with it, like Helm's fullname template does
+func appName(release string, podName string, containerName string) string {
+	return sanitizeName(fmt.Sprintf("%s-%s", releaseName(release, podName), containerName))
+}
+
+// volumeName returns the name of a Docker volume of a release, <release>-<name>. Volume names are global
+// to the host, the prefix keeps two releases with the same workloads from sharing their data
+func volumeName(release string, name string) string {
+	return releaseName(release, name)
+}
+
+// releaseName prefixes a name with the release, unless it already starts with it
+func releaseName(release string, name string) string {
+	if release == "" || name == release || strings.HasPrefix(name, release+"-") {
+		return name
+	}
+	return fmt.Sprintf("%s-%s", release, name)
+}
+
+// sanitizeName turns a name into a DNS label, valid as a compose service name too. Names too long
+// are truncated and end with a hash of the full name, so they stay unique and stable across syncs
+func sanitizeName(name string) string {
+	sanitized := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	for strings.Contains(sanitized, "--") {
+		sanitized = strings.ReplaceAll(sanitized, "--", "-")
+	}
+	if len(sanitized) <= maxNameLength {
+		return sanitized
+	}
+	sum := sha1.Sum([]byte(name))
+	return fmt.Sprintf("%s-%x", strings.TrimRight(sanitized[:maxNameLength-9], "-"), sum[:4])
+}
+
+// freeName appends a suffix to a name taken by another app, and then a number until it is free
+func freeName(name string, suffix string, taken func(string) bool) string {
+	renamed := sanitizeName(fmt.Sprintf("%s-%s", name, suffix))
+	for n := 2; taken(renamed); n++ {
+		renamed = sanitizeName(fmt.Sprintf("%s-%s-%d", name, suffix, n))
+	}
+	return renamed
+}
+
+// renameApps renames apps, along with the depends_on and network_mode references between them,
+// those of compose fragments rendered by external converters included
+func renameApps(apps []spec.App, names map[string]string) {
+	for i := range apps {
+		if service := apps[i].Compose; service != nil {
+			renameServiceRefs(service, names)
+		}
+		if renamed, exists := names[apps[i].Name]; exists {
+			apps[i].Name = renamed
+		}
+		if target, isService := strings.CutPrefix(apps[i].NetworkMode, "service:"); isService {
+			if renamed, exists := names[target]; exists {
+				apps[i].NetworkMode = "service:" + renamed
+			}
+		}
+		if len(apps[i].DependsOn) == 0 {
+			continue
+		}
+		dependsOn := make(map[string]string)
+		for dependency, condition := range apps[i].DependsOn {
+			if renamed, exists := names[dependency]; exists {
+				dependency = renamed
+			}
+			dependsOn[dependency] = condition
+		}
+		apps[i].DependsOn = dependsOn
+	}
+}
+
+// renameServiceRefs renames the services a compose fragment service refers to in depends_on, short or long
+// syntax, and its service: namespaces
+func renameServiceRefs(service map[string]interface{}, names map[string]string) {
+	for _, key := range []string{"network_mode", "pid", "ipc"} {
+		mode, _ := service[key].(string)
+		if target, isService := strings.CutPrefix(mode, "service:"); isService {
+			if renamed, exists := names[target]; exists {
+				service[key] = "service:" + renamed
+			}
+		}
+	}
+	switch dependsOn := service["depends_on"].(type) {
+	case []interface{}:
+		renamedDeps := make([]interface{}, 0, len(dependsOn))
+		for _, dependency := range dependsOn {
+			if renamed, exists := names[fmt.Sprint(dependency)]; exists {
+				dependency = renamed
+			}
+			renamedDeps = append(renamedDeps, dependency)
+		}
+		service["depends_on"] = renamedDeps
+	case map[string]interface{}:
+		renamedDeps := make(map[string]interface{}, len(dependsOn))
+		for dependency, condition := range dependsOn {
+			if renamed, exists := names[dependency]; exists {
+				dependency = renamed
+			}
+			renamedDeps[dependency] = condition
+		}
+		service["depends_on"] = renamedDeps
+	}
+}
@@ -0,0 +1,56 @@
+package charts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAppName(t *testing.T) {
+	tests := []struct {
+		release   string
+		pod       string
+		container string
+		want      string
+	}{
+		{"rel", "web", "nginx", "rel-web-nginx"},
+		{"rel", "rel", "nginx", "rel-nginx"},
+		{"rel", "rel-web", "nginx", "rel-web-nginx"},
+		{"rel", "release-web", "nginx", "rel-release-web-nginx"},
+		{"", "web", "nginx", "web-nginx"},
+		{"rel", "pg-0", "postgres", "rel-pg-0-postgres"},
+		{"Rel", "My_App", "side.car", "rel-my-app-side-car"},
+	}
+	for _, test := range tests {
+		if got := appName(test.release, test.pod, test.container); got != test.want {
+			t.Errorf("appName(%q, %q, %q) = %q, want %q", test.release, test.pod, test.container, got, test.want)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"web", "web"},
+		{"Web_App", "web-app"},
+		{"-web--app-", "web-app"},
+		{"a..b", "a-b"},
+		{strings.Repeat("a", 63), strings.Repeat("a", 63)},
+		{long, strings.Repeat("a", 54) + "-ed6c69d9"},
+	}
+	for _, test := range tests {
+		got := sanitizeName(test.name)
+		if len(got) > maxNameLength {
+			t.Errorf("sanitizeName(%q) = %q, longer than %d characters", test.name, got, maxNameLength)
+		}
+		if got != test.want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+	// names truncated to the same prefix stay unique
+	if sanitizeName(long) == sanitizeName(long+"b") {
+		t.Errorf("sanitizeName truncated %q and %q to the same name", long, long+"b")
+	}
+}
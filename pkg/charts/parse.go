@@ -1,537 +1,686 @@
 package charts
 
 import (
-	"encoding/base64"
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/ashupednekar/compose/pkg/spec"
-	"go.yaml.in/yaml/v3"
+	"helm.sh/helm/v3/pkg/release"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
-func (utils *ChartUtils) Parse(chart string, valuesPath string, setValues []string, useHostNetwork bool) ([]spec.App, error) {
+// releaseResources are the resources of a release pods refer to, looked up by name
+type releaseResources struct {
+	release    string // prefixes the names of the release's apps
+	configMaps map[string]*corev1.ConfigMap
+	secrets    map[string]*corev1.Secret
+	claims     map[string]*corev1.PersistentVolumeClaim
+	services   map[string]spec.ServiceInfo
+	// claimedName returns the name an app of the workload being converted ends up with, see claimAppNames
+	claimedName func(name string) string
+}
+
+// Parse renders a chart and translates its release into apps. hostNetwork lists the workloads to run on the host
+// network on top of those asking for it in their pod spec, see UseHostNetwork
+func (utils *ChartUtils) Parse(chart string, valuesPath string, setValues []string, hostNetwork []string) ([]spec.App, error) {
 	rel, err := utils.Template(chart, valuesPath, setValues)
 	if err != nil {
-		fmt.Printf("error templating chart: %v\n", err)
+		return nil, fmt.Errorf("error templating chart: %v", err)
 	}
 
-	resources := strings.Split(rel.Manifest, "---")
+	return translateRelease(rel.Name, rel.Manifest, rel.Hooks, hostNetwork)
+}
+
+// translateRelease translates the rendered manifest and hooks of a release into apps
+func translateRelease(name string, manifest string, hooks []*release.Hook, hostNetwork []string) ([]spec.App, error) {
+	objects, err := decodeManifests(manifest)
+	if err != nil {
+		return nil, err
+	}
+	var hookObjects []runtime.Object
+	for _, hook := range hooks {
+		decoded, err := decodeManifests(hook.Manifest)
+		if err != nil {
+			fmt.Printf("warning: hook %s - %s\n", hook.Name, err)
+		}
+		hookObjects = append(hookObjects, decoded...)
+	}
 
-	configMaps := make(map[string]interface{})
-	secrets := make(map[string]interface{})
-	services := make(map[string]spec.ServiceInfo)
-	usedPorts := make(map[int]string) // port -> service name mapping for conflict detection
+	ctx := &ConversionContext{
+		resources: &releaseResources{
+			release:    name,
+			configMaps: make(map[string]*corev1.ConfigMap),
+			secrets:    make(map[string]*corev1.Secret),
+			claims:     make(map[string]*corev1.PersistentVolumeClaim),
+			services:   make(map[string]spec.ServiceInfo),
+		},
+		hostNetwork:  make(map[string]bool),
+		hostServices: make(map[string]bool),
+		usedPorts:    make(map[int]string),
+		appWorkloads: make(map[string]string),
+	}
+	for _, override := range hostNetwork {
+		ctx.hostNetwork[strings.ToLower(override)] = false
+	}
 	var apps []spec.App
 
-	// First pass: collect ConfigMaps, Secrets, and Services
-	for _, content := range resources {
-		content = strings.TrimSpace(content)
-		if content == "" {
+	// First pass: collect ConfigMaps, Secrets, PersistentVolumeClaims and Services, hooks included
+	for _, object := range append(append([]runtime.Object{}, objects...), hookObjects...) {
+		if !referencedKinds[object.GetObjectKind().GroupVersionKind().Kind] {
 			continue
 		}
-		var resource spec.Resource
-		if err := yaml.Unmarshal([]byte(content), &resource); err != nil {
-			fmt.Printf("warning: error unmarshalling resource - %s", err)
+		// converters replacing the built-in ones may render services too
+		converted, err := convertObject(object, ctx)
+		if err != nil {
+			fmt.Printf("warning: %s\n", err)
 			continue
 		}
-		
-		if resource.Kind == "ConfigMap" {
-			name := getStringFromMap(resource.Metadata, "name")
-			if name != "" {
-				configMaps[name] = resource.Data
-			}
-		} else if resource.Kind == "Secret" {
-			name := getStringFromMap(resource.Metadata, "name")
-			if name != "" {
-				secrets[name] = resource.Data
-			}
-		} else if resource.Kind == "Service" {
-			serviceInfo, err := extractServiceInfo(resource, useHostNetwork, usedPorts)
-			if err != nil {
-				fmt.Printf("warning: error processing service - %s\n", err)
-				continue
-			}
-			if serviceInfo != nil {
-				name := getStringFromMap(resource.Metadata, "name")
-				services[name] = *serviceInfo
-			}
-		}
+		apps = append(apps, converted...)
 	}
 
-	if useHostNetwork {
-		configMaps = replaceServiceNamesWithLocalhost(configMaps, services)
+	ctx.findHostServices(append(append([]runtime.Object{}, objects...), hookObjects...))
+
+	// Second pass: convert workloads and everything else with a registered converter
+	for _, object := range objects {
+		if referencedKinds[object.GetObjectKind().GroupVersionKind().Kind] {
+			continue
+		}
+		converted, err := convertObject(object, ctx)
+		if err != nil {
+			fmt.Printf("error converting %s %s: %v\n", object.GetObjectKind().GroupVersionKind().Kind, objectName(object), err)
+			continue
+		}
+		apps = append(apps, converted...)
 	}
 
-	// Second pass: process Deployments and StatefulSets
-	for _, content := range resources {
-		content = strings.TrimSpace(content)
-		if content == "" {
+	// Third pass: Helm hooks, run once around the rollout by restart.sh
+	for _, hook := range hooks {
+		if hook.Kind != "Job" && hook.Kind != "Pod" {
 			continue
 		}
-		var resource spec.Resource
-		if err := yaml.Unmarshal([]byte(content), &resource); err != nil {
+		hookSpec := hookSpecFor(hook)
+		if hookSpec == nil {
+			continue
+		}
+		object, err := decodeManifest([]byte(hook.Manifest))
+		if err != nil {
+			fmt.Printf("warning: error decoding hook %s - %s\n", hook.Name, err)
+			continue
+		}
+		if object == nil {
 			continue
 		}
 
-		if resource.Kind == "Deployment" || resource.Kind == "StatefulSet" {
-			// Extract all containers (main + sidecars) from the pod
-			podApps, err := extractPodApps(resource, configMaps, secrets, services, useHostNetwork)
-			if err != nil {
-				fmt.Printf("error extracting pod apps: %v\n", err)
-				continue
-			}
-			
-			if len(podApps) > 0 {
-				// Handle sidecar networking
-				if len(podApps) > 1 && !useHostNetwork {
-					// Multiple containers in pod - setup shared network namespace
-					mainApp := &podApps[0]
-					mainApp.NetworkMode = ""
-					
-					for i := 1; i < len(podApps); i++ {
-						sidecar := &podApps[i]
-						sidecar.NetworkMode = fmt.Sprintf("service:%s", mainApp.Name)
-						sidecar.Ports = []string{} // Sidecars don't expose ports directly
-					}
-				} else if useHostNetwork {
-					// Host networking for all containers
-					for i := range podApps {
-						podApps[i].NetworkMode = "host"
-						podApps[i].Ports = []string{} // No port mapping needed with host network
-					}
-				}
-				
-				apps = append(apps, podApps...)
+		hookApps, err := convertObject(object, ctx)
+		if err != nil {
+			fmt.Printf("error extracting hook %s: %v\n", hook.Name, err)
+			continue
+		}
+		for i := range hookApps {
+			hookApps[i].Hook = hookSpec
+			// hook Pods run once, like hook Jobs with no retries
+			if hookApps[i].Job == nil {
+				hookApps[i].Job = &spec.JobSpec{}
+				hookApps[i].Restart = "no"
 			}
 		}
+		apps = append(apps, hookApps...)
+	}
+
+	if ctx.hostRewriter != nil {
+		ctx.hostRewriter.report()
+	}
+	for _, override := range hostNetwork {
+		if !ctx.hostNetwork[strings.ToLower(override)] {
+			fmt.Printf("warning: host network override %s matches no workload\n", override)
+		}
 	}
+
+	hostGatewayHosts(apps, ctx.resources.services, ctx.hostServices)
+
+	// Services forwarding to a different port get a proxy listening on the Service port, named like
+	// the apps of a workload, so a container called proxy may already have its name
+	proxies := serviceProxies(apps, ctx.resources.services, name)
+	for i := range proxies {
+		ctx.claimAppNames(proxies[i:i+1], "Service "+proxies[i].Labels[labelPrefix+"service"])
+	}
+	apps = append(apps, proxies...)
 	
 	return apps, nil
 }
 
-func extractServiceInfo(resource spec.Resource, useHostNetwork bool, usedPorts map[int]string) (*spec.ServiceInfo, error) {
-	name := getStringFromMap(resource.Metadata, "name")
-	if name == "" {
-		return nil, fmt.Errorf("service missing metadata.name")
-	}
-	ports, ok := resource.Spec["ports"]
-	if !ok {
-		return nil, fmt.Errorf("service missing ports")
-	}
-	portsSlice, ok := ports.([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("service ports not in expected format")
+// Extract the apps of a workload, one set per pod
+func extractWorkloadApps(w *workload, res *releaseResources) ([]spec.App, error) {
+	// StatefulSet pods get stable identities, <name>-0 to <name>-N-1. So do the replicas of Deployments with
+	// sidecars: network_mode service:<main> would join every sidecar replica to the first main replica
+	podNames := []string{w.name}
+	replicaPods := w.kind == "Deployment" && w.replicas > 1 && !w.template.Spec.HostNetwork && len(w.template.Spec.Containers) > 1
+	if w.kind == "StatefulSet" || replicaPods {
+		podNames = []string{}
+		for ordinal := 0; ordinal < w.replicas; ordinal++ {
+			podNames = append(podNames, fmt.Sprintf("%s-%d", w.name, ordinal))
+		}
 	}
-	serviceInfo := &spec.ServiceInfo{
-		Name:     name,
-		Ports:    []spec.PortInfo{},
-		Selector: make(map[string]string),
-	}
-	if selector, exists := resource.Spec["selector"]; exists {
-		if selectorMap, ok := selector.(map[string]interface{}); ok {
-			for k, v := range selectorMap {
-				if strVal, ok := v.(string); ok {
-					serviceInfo.Selector[k] = strVal
-				}
+
+	var apps []spec.App
+	var replicaPorts [][]string // host port ranges of the first replica pod's containers, one port per replica
+	previousPod := ""
+	for ordinal, podName := range podNames {
+		// Extract all containers (main + sidecars) from the pod
+		podApps, err := extractPodApps(w, podName, res)
+		if err != nil {
+			return nil, err
+		}
+
+		// Job pods run to completion, retries are left to run-job.sh
+		if w.job != nil {
+			for i := range podApps {
+				podApps[i].Job = w.job
+				podApps[i].Restart = "no"
 			}
 		}
-	}
-	for _, portInterface := range portsSlice {
-		if portMap, ok := portInterface.(map[string]interface{}); ok {
-			portInfo := spec.PortInfo{}
-			
-			if port, exists := portMap["port"]; exists {
-				if portInt, ok := port.(int); ok {
-					portInfo.Port = portInt
-				} else if portStr, ok := port.(string); ok {
-					if p, err := strconv.Atoi(portStr); err == nil {
-						portInfo.Port = p
-					}
+
+		// Init containers keep their own network namespace, the main container
+		// only starts once all of them have completed
+		var podContainers []*spec.App
+		for i := range podApps {
+			if !podApps[i].Init {
+				podContainers = append(podContainers, &podApps[i])
+			}
+		}
+		if len(podContainers) == 0 {
+			continue
+		}
+
+		if w.kind == "StatefulSet" {
+			setStatefulSetIdentity(podContainers[0], w, podName)
+			// every ordinal would publish the same host ports, only the first one does
+			if ordinal > 0 {
+				for i := range podApps {
+					podApps[i].Ports = []string{}
 				}
 			}
-			
-			if targetPort, exists := portMap["targetPort"]; exists {
-				if targetPortInt, ok := targetPort.(int); ok {
-					portInfo.Port = targetPortInt
-				} else if targetPortStr, ok := targetPort.(string); ok {
-					if tp, err := strconv.Atoi(targetPortStr); err == nil {
-						portInfo.Port = tp
-					}
+			// OrderedReady pods start one after the other
+			if previousPod != "" && w.podManagementPolicy != appsv1.ParallelPodManagement {
+				if podContainers[0].DependsOn == nil {
+					podContainers[0].DependsOn = make(map[string]string)
 				}
-			} else {
-				// If targetPort is not specified, it defaults to port
-				portInfo.Port = portInfo.Port
+				podContainers[0].DependsOn[previousPod] = "service_started"
 			}
-			
-			if protocol, exists := portMap["protocol"]; exists {
-				if protocolStr, ok := protocol.(string); ok {
-					portInfo.Protocol = protocolStr
+		} else if replicaPods {
+			if replicaPorts == nil {
+				scalePodPorts(podApps, w.replicas, w.name)
+				for i := range podApps {
+					replicaPorts = append(replicaPorts, podApps[i].Ports)
 				}
-			} else {
-				portInfo.Protocol = "TCP"
 			}
+			for i := range podApps {
+				podApps[i].Ports = replicaPortsOf(replicaPorts[i], ordinal)
+			}
+		} else if w.kind == "Deployment" && w.replicas != 1 {
+			replicas := w.replicas
+			for i := range podApps {
+				podApps[i].Replicas = &replicas
+			}
+			scalePodPorts(podApps, replicas, podName)
+		}
 
-			if useHostNetwork {
-				if existingService, exists := usedPorts[portInfo.Port]; exists {
-					return nil, fmt.Errorf("port conflict: port %d is already used by service %s, cannot be used by service %s", 
-						portInfo.Port, existingService, name)
-				}
-				usedPorts[portInfo.Port] = name
+		// Handle sidecar networking, host networked containers all share the host's
+		if len(podContainers) > 1 && !w.template.Spec.HostNetwork {
+			// Multiple containers in pod - setup shared network namespace
+			mainApp := podContainers[0]
+			mainApp.NetworkMode = ""
+
+			for i := 1; i < len(podContainers); i++ {
+				sidecar := podContainers[i]
+				// Sidecars listen in the main container's network namespace, they keep their own ports and
+				// DNS names, moved to it when written, since their namespace is their own with the app layout
+				sidecar.NetworkMode = fmt.Sprintf("service:%s", mainApp.Name)
 			}
-			serviceInfo.Ports = append(serviceInfo.Ports, portInfo)
 		}
+
+		// A DaemonSet is a single instance on a single host
+		if w.kind == "DaemonSet" {
+			reportUnschedulable(w)
+		}
+
+		previousPod = podContainers[0].Name
+		apps = append(apps, podApps...)
 	}
 
-	return serviceInfo, nil
+	return apps, nil
 }
 
-func replaceServiceNamesWithLocalhost(configMaps map[string]interface{}, services map[string]spec.ServiceInfo) map[string]interface{} {
-	updatedConfigMaps := make(map[string]interface{})
-	
-	for configMapName, configMapData := range configMaps {
-		if cfgMap, ok := configMapData.(map[string]interface{}); ok {
-			updatedCfgMap := make(map[string]interface{})
-			
-			for key, value := range cfgMap {
-				if strValue, ok := value.(string); ok {
-					updatedValue := strValue
-					// Replace each service name with localhost
-					for serviceName := range services {
-						pattern := regexp.MustCompile(`\b` + regexp.QuoteMeta(serviceName) + `\b`)
-						updatedValue = pattern.ReplaceAllString(updatedValue, "localhost")
-					}
-					updatedCfgMap[key] = updatedValue
-				} else {
-					updatedCfgMap[key] = value
+// setStatefulSetIdentity gives the main container of a StatefulSet pod the stable hostname
+// and headless Service DNS names Kubernetes would
+func setStatefulSetIdentity(mainApp *spec.App, w *workload, podName string) {
+	mainApp.Hostname = podName
+	serviceName := w.serviceName
+	if serviceName == "" {
+		return
+	}
+	namespace := w.namespace
+	mainApp.Domainname = fmt.Sprintf("%s.%s.svc.cluster.local", serviceName, namespace)
+	mainApp.Aliases = append(mainApp.Aliases,
+		fmt.Sprintf("%s.%s", podName, serviceName),
+		fmt.Sprintf("%s.%s.%s", podName, serviceName, namespace),
+		fmt.Sprintf("%s.%s.%s.svc", podName, serviceName, namespace),
+		fmt.Sprintf("%s.%s.%s.svc.cluster.local", podName, serviceName, namespace),
+	)
+}
+
+// scaledPortRange widens the host side of a "host:container" port mapping into a range,
+// so each replica of a scaled service gets its own host port
+func scaledPortRange(port string, replicas int) string {
+	mapping, protocol, hasProtocol := strings.Cut(port, "/")
+	hostPort, containerPort, found := strings.Cut(mapping, ":")
+	if !found {
+		return port
+	}
+	host, err := strconv.Atoi(hostPort)
+	if err != nil || replicas < 2 {
+		return port
+	}
+	scaled := fmt.Sprintf("%d-%d:%s", host, host+replicas-1, containerPort)
+	if hasProtocol {
+		scaled += "/" + protocol
+	}
+	return scaled
+}
+
+// scalePodPorts widens the published ports of a scaled pod into ranges. Ports next to each other, like
+// nodePorts 30080 and 30081, would overlap once widened, the later ones are skipped with a warning
+func scalePodPorts(podApps []spec.App, replicas int, podName string) {
+	type portRange struct {
+		mapping     string
+		first, last int
+	}
+	taken := make(map[string][]portRange) // protocol -> host port ranges published so far
+	for i := range podApps {
+		ports := []string{}
+		for _, port := range podApps[i].Ports {
+			scaled := scaledPortRange(port, replicas)
+			first, last, protocol, ok := hostPortRange(scaled)
+			if !ok {
+				ports = append(ports, scaled)
+				continue
+			}
+			overlap := ""
+			for _, published := range taken[protocol] {
+				if first <= published.last && published.first <= last {
+					overlap = published.mapping
+					break
 				}
 			}
-			updatedConfigMaps[configMapName] = updatedCfgMap
-		} else {
-			updatedConfigMaps[configMapName] = configMapData
+			if overlap != "" {
+				fmt.Printf("warning: %s: host ports %s overlap with %s once scaled to %d replicas, not publishing them\n", podName, scaled, overlap, replicas)
+				continue
+			}
+			taken[protocol] = append(taken[protocol], portRange{mapping: scaled, first: first, last: last})
+			ports = append(ports, scaled)
 		}
+		podApps[i].Ports = ports
 	}
-	
-	return updatedConfigMaps
 }
 
-// Extract all containers from a pod (main + sidecars)
-func extractPodApps(resource spec.Resource, configMaps map[string]interface{}, secrets map[string]interface{}, services map[string]spec.ServiceInfo, useHostNetwork bool) ([]spec.App, error) {
-	podName := getStringFromMap(resource.Metadata, "name")
-	if podName == "" {
-		return nil, fmt.Errorf("missing metadata.name")
+// replicaPortsOf returns the port mappings of one replica pod, the ordinal-th host port of each range
+func replicaPortsOf(ports []string, ordinal int) []string {
+	replicaPorts := []string{}
+	for _, port := range ports {
+		first, _, _, ok := hostPortRange(port)
+		if !ok {
+			replicaPorts = append(replicaPorts, port)
+			continue
+		}
+		mapping, protocol, hasProtocol := strings.Cut(port, "/")
+		_, containerPort, _ := strings.Cut(mapping, ":")
+		replicaPort := fmt.Sprintf("%d:%s", first+ordinal, containerPort)
+		if hasProtocol {
+			replicaPort += "/" + protocol
+		}
+		replicaPorts = append(replicaPorts, replicaPort)
 	}
+	return replicaPorts
+}
 
-	specs, ok := resource.Spec["template"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("missing spec.template")
+// hostPortRange returns the host ports of a "host:container" port mapping, a single port or a range, and its protocol
+func hostPortRange(port string) (int, int, string, bool) {
+	mapping, protocol, _ := strings.Cut(port, "/")
+	if protocol == "" {
+		protocol = "tcp"
 	}
-
-	templateSpec, ok := specs["spec"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("missing spec.template.spec")
+	hostPorts, _, found := strings.Cut(mapping, ":")
+	if !found {
+		return 0, 0, "", false
+	}
+	firstPort, lastPort, isRange := strings.Cut(hostPorts, "-")
+	first, err := strconv.Atoi(firstPort)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	last := first
+	if isRange {
+		if last, err = strconv.Atoi(lastPort); err != nil {
+			return 0, 0, "", false
+		}
 	}
+	return first, last, protocol, true
+}
 
-	containersInterface, ok := templateSpec["containers"]
-	if !ok {
-		return nil, fmt.Errorf("missing containers")
+// reportUnschedulable warns about scheduling constraints that mean nothing on a single host
+func reportUnschedulable(w *workload) {
+	podSpec := w.template.Spec
+	for _, constraint := range []struct {
+		field string
+		set   bool
+	}{
+		{"nodeSelector", len(podSpec.NodeSelector) > 0},
+		{"tolerations", len(podSpec.Tolerations) > 0},
+		{"affinity", podSpec.Affinity != nil},
+	} {
+		if constraint.set {
+			fmt.Printf("warning: %s %s: %s cannot be honored on a single host, ignoring\n", w.kind, w.name, constraint.field)
+		}
 	}
+}
 
-	containers, ok := containersInterface.([]interface{})
-	if !ok || len(containers) == 0 {
-		return nil, fmt.Errorf("no containers found")
+func extractServiceInfo(service *corev1.Service) (*spec.ServiceInfo, error) {
+	name := service.Name
+	if name == "" {
+		return nil, fmt.Errorf("service missing metadata.name")
 	}
+	if len(service.Spec.Ports) == 0 {
+		return nil, fmt.Errorf("service missing ports")
+	}
+	serviceType := string(service.Spec.Type)
+	if serviceType == "" {
+		serviceType = string(corev1.ServiceTypeClusterIP)
+	}
+	serviceInfo := &spec.ServiceInfo{
+		Name:      name,
+		Namespace: resourceNamespace(service),
+		Type:      serviceType,
+		ClusterIP: service.Spec.ClusterIP,
+		Ports:     []spec.PortInfo{},
+		Selector:  make(map[string]string),
+	}
+	for k, v := range service.Spec.Selector {
+		serviceInfo.Selector[k] = v
+	}
+	for _, port := range service.Spec.Ports {
+		portInfo := spec.PortInfo{
+			Name:     port.Name,
+			Port:     int(port.Port),
+			NodePort: int(port.NodePort),
+			Protocol: string(port.Protocol),
+		}
 
-	// Get pod labels for service matching
-	labels := make(map[string]string)
-	if metadata, exists := specs["metadata"]; exists {
-		if metadataMap, ok := metadata.(map[string]interface{}); ok {
-			if labelsInterface, exists := metadataMap["labels"]; exists {
-				if labelsMap, ok := labelsInterface.(map[string]interface{}); ok {
-					for k, v := range labelsMap {
-						if strVal, ok := v.(string); ok {
-							labels[k] = strVal
-						}
-					}
-				}
+		// targetPort is either a number or the name of a containerPort, and defaults to port
+		switch {
+		case port.TargetPort.Type == intstr.String:
+			if tp, err := strconv.Atoi(port.TargetPort.StrVal); err == nil {
+				portInfo.TargetPort = tp
+			} else {
+				portInfo.TargetPortName = port.TargetPort.StrVal
 			}
+		case port.TargetPort.IntVal != 0:
+			portInfo.TargetPort = int(port.TargetPort.IntVal)
+		default:
+			portInfo.TargetPort = portInfo.Port
 		}
+		if portInfo.Protocol == "" {
+			portInfo.Protocol = string(corev1.ProtocolTCP)
+		}
+
+		serviceInfo.Ports = append(serviceInfo.Ports, portInfo)
 	}
 
+	return serviceInfo, nil
+}
+
+// Extract all containers from a pod (main + sidecars)
+func extractPodApps(w *workload, podName string, res *releaseResources) ([]spec.App, error) {
+	if podName == "" {
+		return nil, fmt.Errorf("missing metadata.name")
+	}
+
+	templateSpec := w.template.Spec
+	if len(templateSpec.Containers) == 0 {
+		return nil, fmt.Errorf("no containers found")
+	}
+
+	// The downward API describes this pod, not the workload
+	pod := newPodIdentity(w, podName, res)
+
+	// Get pod labels for service matching
+	labels := w.template.Labels
+
+	podPorts := publishedPorts(res.services, labels, templateSpec.Containers, podName)
+
+	// initContainers run one after the other, to completion, before the containers start
+	initContainers := templateSpec.InitContainers
+	var previousInit string
+
 	var apps []spec.App
 	
-	// Process each container
-	for i, containerInterface := range containers {
-		container, ok := containerInterface.(map[string]interface{})
-		if !ok {
-			continue
-		}
+	// Process each container, init containers first
+	for i, container := range append(append([]corev1.Container{}, initContainers...), templateSpec.Containers...) {
+		isInit := i < len(initContainers)
 
-		containerName := getStringFromMap(container, "name")
+		containerName := container.Name
 		if containerName == "" {
-			containerName = fmt.Sprintf("%s-%d", podName, i)
+			if isInit {
+				containerName = fmt.Sprintf("init-%d", i)
+			} else {
+				containerName = fmt.Sprintf("container-%d", i-len(initContainers))
+			}
+			container.Name = containerName
 		}
 
 		app := spec.App{
-			Name:    containerName,
-			Type:    resource.Kind,
-			Image:   getStringFromMap(container, "image"),
-			Configs: make(map[string]string),
-			Mounts:  make(map[string]string),
-			Ports:   []string{},
-		}
-
-		// Only add ports to the first container (main container)
-		if i == 0 {
-			for _, serviceInfo := range services {
-				if matchesSelector(labels, serviceInfo.Selector) {
-					for _, portInfo := range serviceInfo.Ports {
-						if !useHostNetwork {
-							app.Ports = append(app.Ports, fmt.Sprintf("%d:%d", portInfo.Port, portInfo.Port))
-						}
-					}
-					break
+			Name:                  appName(res.release, podName, containerName),
+			Type:                  w.kind,
+			Image:                 container.Image,
+			Configs:               make(map[string]string),
+			Mounts:                make(map[string]string),
+			Ports:                 []string{},
+			Init:                  isInit,
+			Pod:                   podName,
+			ShareProcessNamespace: templateSpec.ShareProcessNamespace != nil && *templateSpec.ShareProcessNamespace && !templateSpec.HostPID,
+			// the names the app was generated from
+			Labels: map[string]string{
+				labelPrefix + "release":   res.release,
+				labelPrefix + "kind":      w.kind,
+				labelPrefix + "workload":  w.name,
+				labelPrefix + "pod":       podName,
+				labelPrefix + "container": containerName,
+			},
+		}
+
+		// Chain each init container after the previous one, and the main container after the last
+		if isInit {
+			app.Restart = "no"
+		}
+		if previousInit != "" && (isInit || i == len(initContainers)) {
+			app.DependsOn = map[string]string{previousInit: "service_completed_successfully"}
+		}
+		if isInit {
+			previousInit = app.Name
+		}
+
+		// Pods share the host's namespaces when asked to, host networked containers listen on the host directly
+		if templateSpec.HostNetwork {
+			app.NetworkMode = "host"
+		}
+		if templateSpec.HostPID {
+			app.Pid = "host"
+		}
+		if templateSpec.HostIPC {
+			app.Ipc = "host"
+		}
+
+		// Services publish their ports on the container declaring them, and are resolved by its name
+		if !isInit && !templateSpec.HostNetwork {
+			app.Ports = podPorts[i-len(initContainers)]
+		}
+		if !isInit {
+			app.Aliases = serviceAliases(res.services, labels, templateSpec.Containers, i-len(initContainers))
+			app.Endpoints = serviceEndpoints(res.services, labels, templateSpec.Containers, i-len(initContainers))
+		}
+
+		// Kubernetes does not allow probes on init containers
+		if !isInit {
+			app.Healthcheck = containerHealthcheck(container)
+		}
+		app.Resources = containerResources(container)
+		app.Security = containerSecurity(templateSpec, container)
+
+		// Extract command and args
+		app.Command = append(append(app.Command, container.Command...), container.Args...)
+
+		// Extract lifecycle hooks
+		if container.Lifecycle != nil && container.Lifecycle.PostStart != nil {
+			postStart := container.Lifecycle.PostStart
+			if postStart.Exec != nil {
+				app.PostStart = &spec.PostStartHook{Type: "exec", Command: postStart.Exec.Command}
+			} else if postStart.HTTPGet != nil {
+				app.PostStart = &spec.PostStartHook{
+					Type:    "httpGet",
+					HTTPGet: fmt.Sprintf("%s:%s", postStart.HTTPGet.Path, postStart.HTTPGet.Port.String()),
 				}
 			}
 		}
 
-		// Extract command and args
-		if cmdInterface, exists := container["command"]; exists {
-			if cmdSlice, ok := cmdInterface.([]interface{}); ok {
-				for _, cmd := range cmdSlice {
-					if cmdStr, ok := cmd.(string); ok {
-						app.Command = append(app.Command, cmdStr)
+		// Extract envFrom (ConfigMap and Secret references)
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				if configMap, exists := res.configMaps[envFrom.ConfigMapRef.Name]; exists {
+					for k, v := range configMapData(configMap) {
+						app.Configs[envFrom.Prefix+k] = v
 					}
 				}
 			}
-		}
-		if argsInterface, exists := container["args"]; exists {
-			if argsSlice, ok := argsInterface.([]interface{}); ok {
-				for _, arg := range argsSlice {
-					if argStr, ok := arg.(string); ok {
-						app.Command = append(app.Command, argStr)
+			if envFrom.SecretRef != nil {
+				secretName := envFrom.SecretRef.Name
+				secret, exists := res.secrets[secretName]
+				if !exists {
+					if optional := envFrom.SecretRef.Optional; optional != nil && *optional {
+						continue
 					}
+					return nil, fmt.Errorf("container %s: secret %s referenced by envFrom not found", containerName, secretName)
+				}
+				for k, v := range secretData(secret) {
+					app.Configs[envFrom.Prefix+k] = v
 				}
 			}
 		}
 
-		// Extract lifecycle hooks
-		if lifecycleInterface, exists := container["lifecycle"]; exists {
-			if lifecycle, ok := lifecycleInterface.(map[string]interface{}); ok {
-				if postStartInterface, exists := lifecycle["postStart"]; exists {
-					if postStart, ok := postStartInterface.(map[string]interface{}); ok {
-						hook := &spec.PostStartHook{}
-						if execInterface, exists := postStart["exec"]; exists {
-							if exec, ok := execInterface.(map[string]interface{}); ok {
-								hook.Type = "exec"
-								if cmdInterface, exists := exec["command"]; exists {
-									if cmdSlice, ok := cmdInterface.([]interface{}); ok {
-										for _, cmd := range cmdSlice {
-											if cmdStr, ok := cmd.(string); ok {
-												hook.Command = append(hook.Command, cmdStr)
-											}
-										}
-									}
-								}
-							}
-						} else if httpGetInterface, exists := postStart["httpGet"]; exists {
-							if httpGet, ok := httpGetInterface.(map[string]interface{}); ok {
-								hook.Type = "httpGet"
-								path := getStringFromMap(httpGet, "path")
-								port := getStringFromMap(httpGet, "port")
-								hook.HTTPGet = fmt.Sprintf("%s:%s", path, port)
-							}
-						}
-						if hook.Type != "" {
-							app.PostStart = hook
-						}
+		// Handle environment variables
+		for _, env := range container.Env {
+			envKey := env.Name
+			if envKey == "" {
+				continue
+			}
+
+			// values may refer to envFrom variables and the env entries before them
+			if env.ValueFrom == nil {
+				app.Configs[envKey] = expandVars(env.Value, app.Configs)
+				continue
+			}
+
+			if keyRef := env.ValueFrom.ConfigMapKeyRef; keyRef != nil {
+				if configMap, exists := res.configMaps[keyRef.Name]; exists {
+					if value, exists := configMapData(configMap)[keyRef.Key]; exists {
+						app.Configs[envKey] = value
 					}
 				}
 			}
-		}
 
-		// Extract envFrom (ConfigMap references)
-		if envFromInterface, exists := container["envFrom"]; exists {
-			if envFromSlice, ok := envFromInterface.([]interface{}); ok {
-				for _, envFrom := range envFromSlice {
-					if envFromMap, ok := envFrom.(map[string]interface{}); ok {
-						if configMapRef, exists := envFromMap["configMapRef"]; exists {
-							if configMapRefMap, ok := configMapRef.(map[string]interface{}); ok {
-								configMapName := getStringFromMap(configMapRefMap, "name")
-								if config, exists := configMaps[configMapName]; exists {
-									cfgMap, ok := config.(map[string]interface{})
-									if !ok {
-										return nil, fmt.Errorf("invalid configmap state")
-									}
-									for k, v := range cfgMap {
-										app.Configs[k] = fmt.Sprintf("%v", v)
-									}
-								}
-							}
-						}
-					}
+			if keyRef := env.ValueFrom.SecretKeyRef; keyRef != nil {
+				value, found := lookupSecretKey(res.secrets, keyRef.Name, keyRef.Key)
+				if found {
+					app.Configs[envKey] = value
+				} else if keyRef.Optional == nil || !*keyRef.Optional {
+					return nil, fmt.Errorf("container %s: env %s requires key %s of secret %s, which does not exist", containerName, envKey, keyRef.Key, keyRef.Name)
 				}
 			}
-		}
 
-		// Handle environment variables
-		if envInterface, exists := container["env"]; exists {
-			if envSlice, ok := envInterface.([]interface{}); ok {
-				for _, env := range envSlice {
-					if envMap, ok := env.(map[string]interface{}); ok {
-						envKey := getStringFromMap(envMap, "name")
-						if envKey == "" {
-							continue
-						}
-						
-						if value, exists := envMap["value"]; exists {
-							app.Configs[envKey] = fmt.Sprintf("%v", value)
-						}
-						
-						if valueFrom, exists := envMap["valueFrom"]; exists {
-							if valueFromMap, ok := valueFrom.(map[string]interface{}); ok {
-								if configMapKeyRef, exists := valueFromMap["configMapKeyRef"]; exists {
-									if keyRefMap, ok := configMapKeyRef.(map[string]interface{}); ok {
-										configMapName := getStringFromMap(keyRefMap, "name")
-										key := getStringFromMap(keyRefMap, "key")
-										if config, exists := configMaps[configMapName]; exists {
-											if cfg, ok := config.(map[string]interface{}); ok {
-												if value, exists := cfg[key]; exists {
-													app.Configs[envKey] = fmt.Sprintf("%v", value)
-												}
-											} else {
-												return nil, fmt.Errorf("invalid configmap state")
-											}
-										}
-									}
-								}
-								
-								if fieldRef, exists := valueFromMap["fieldRef"]; exists {
-									if fieldRefMap, ok := fieldRef.(map[string]interface{}); ok {
-										fieldPath := getStringFromMap(fieldRefMap, "fieldPath")
-										if fieldPath != "" {
-											value := getValueFromFieldPath(resource, fieldPath)
-											if value != "" {
-												app.Configs[envKey] = value
-											}
-										}
-									}
-								}
-							}
-						}
-					}
+			if fieldRef := env.ValueFrom.FieldRef; fieldRef != nil {
+				if value, supported := pod.fieldRef(fieldRef.FieldPath); supported {
+					app.Configs[envKey] = value
+				} else {
+					fmt.Printf("warning: container %s: env %s refers to unsupported field %s, skipping\n", containerName, envKey, fieldRef.FieldPath)
+				}
+			}
+
+			if resourceFieldRef := env.ValueFrom.ResourceFieldRef; resourceFieldRef != nil {
+				if value, err := pod.resourceFieldRef(resourceFieldRef, containerName); err == nil {
+					app.Configs[envKey] = value
+				} else {
+					fmt.Printf("warning: container %s: env %s: %v, skipping\n", containerName, envKey, err)
 				}
 			}
 		}
 
+		// command and args may refer to any of the container's environment variables
+		for j := range app.Command {
+			app.Command[j] = expandVars(app.Command[j], app.Configs)
+		}
+
+		// Handle StatefulSet volumeClaimTemplates, mounted by name without a pod volume
+		app.Volumes = append(app.Volumes, claimTemplateVolumes(container, w.claimTemplates, podName, res.release)...)
+
 		// Handle volume mounts
-		if volumeMountsInterface, exists := container["volumeMounts"]; exists {
-			if volumeMounts, ok := volumeMountsInterface.([]interface{}); ok {
-				if volumesInterface, exists := templateSpec["volumes"]; exists {
-					if volumes, ok := volumesInterface.([]interface{}); ok {
-						for _, volumeMount := range volumeMounts {
-							if mountMap, ok := volumeMount.(map[string]interface{}); ok {
-								mountName := getStringFromMap(mountMap, "name")
-								mountPath := getStringFromMap(mountMap, "mountPath")
-								subPath := getStringFromMap(mountMap, "subPath")
-		
-								for _, volume := range volumes {
-									if volumeMap, ok := volume.(map[string]interface{}); ok {
-										volumeName := getStringFromMap(volumeMap, "name")
-										if volumeName == mountName {
-											// Handle ConfigMap volumes
-											if configMap, exists := volumeMap["configMap"]; exists {
-												if configMapMap, ok := configMap.(map[string]interface{}); ok {
-													configMapName := getStringFromMap(configMapMap, "name")
-													if config, exists := configMaps[configMapName]; exists {
-														if cfgData, ok := config.(map[string]interface{}); ok {
-															// If subPath is specified, mount specific file at mountPath
-															if subPath != "" {
-																if value, exists := cfgData[subPath]; exists {
-																	app.Mounts[mountPath] = fmt.Sprintf("%v", value)
-																}
-															} else if itemsInterface, hasItems := configMapMap["items"]; hasItems {
-																// Check if specific items are defined
-																if items, ok := itemsInterface.([]interface{}); ok {
-																	for _, item := range items {
-																		if itemMap, ok := item.(map[string]interface{}); ok {
-																			key := getStringFromMap(itemMap, "key")
-																			path := getStringFromMap(itemMap, "path")
-																			if value, exists := cfgData[key]; exists {
-																				fullPath := mountPath + "/" + path
-																				app.Mounts[fullPath] = fmt.Sprintf("%v", value)
-																			}
-																		}
-																	}
-																}
-															} else {
-																// Mount all keys from ConfigMap
-																for key, value := range cfgData {
-																	fullPath := mountPath + "/" + key
-																	app.Mounts[fullPath] = fmt.Sprintf("%v", value)
-																}
-															}
-														}
-													}
-												}
-											}
-											
-											// Handle Secret volumes
-											if secret, exists := volumeMap["secret"]; exists {
-												if secretMap, ok := secret.(map[string]interface{}); ok {
-													secretName := getStringFromMap(secretMap, "secretName")
-													if secretData, exists := secrets[secretName]; exists {
-														if secData, ok := secretData.(map[string]interface{}); ok {
-															// If subPath is specified, mount specific file at mountPath
-															if subPath != "" {
-																if encodedValue, exists := secData[subPath]; exists {
-																	if decodedBytes, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%v", encodedValue)); err == nil {
-																		app.Mounts[mountPath] = string(decodedBytes)
-																	} else {
-																		fmt.Printf("warning: failed to decode base64 for secret %s key %s: %v\n", secretName, subPath, err)
-																	}
-																}
-															} else if itemsInterface, hasItems := secretMap["items"]; hasItems {
-																// Check if specific items are defined
-																if items, ok := itemsInterface.([]interface{}); ok {
-																	for _, item := range items {
-																		if itemMap, ok := item.(map[string]interface{}); ok {
-																			key := getStringFromMap(itemMap, "key")
-																			path := getStringFromMap(itemMap, "path")
-																			if encodedValue, exists := secData[key]; exists {
-																				// Decode base64
-																				if decodedBytes, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%v", encodedValue)); err == nil {
-																					fullPath := mountPath + "/" + path
-																					app.Mounts[fullPath] = string(decodedBytes)
-																				} else {
-																					fmt.Printf("warning: failed to decode base64 for secret %s key %s: %v\n", secretName, key, err)
-																				}
-																			}
-																		}
-																	}
-																}
-															} else {
-																// Mount all keys from Secret
-																for key, encodedValue := range secData {
-																	if decodedBytes, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%v", encodedValue)); err == nil {
-																		fullPath := mountPath + "/" + key
-																		app.Mounts[fullPath] = string(decodedBytes)
-																	} else {
-																		fmt.Printf("warning: failed to decode base64 for secret %s key %s: %v\n", secretName, key, err)
-																	}
-																}
-															}
-														}
-													}
-												}
-											}
-										}
-									}
-								}
-							}
-						}
+		for _, mount := range container.VolumeMounts {
+			for _, volume := range templateSpec.Volumes {
+				if volume.Name != mount.Name {
+					continue
+				}
+				source := volume.VolumeSource
+
+				// Handle ConfigMap and Secret volumes
+				if source.ConfigMap != nil {
+					mountVolumeFiles(&app, configMapFiles(source.ConfigMap.Name, source.ConfigMap.Items, source.ConfigMap.Optional, res.configMaps), mount)
+				}
+				if source.Secret != nil {
+					mountVolumeFiles(&app, secretFiles(source.Secret.SecretName, source.Secret.Items, source.Secret.Optional, res.secrets), mount)
+				}
+
+				// Handle emptyDir volumes, shared by every container of the pod
+				if source.EmptyDir != nil {
+					app.Volumes = append(app.Volumes, emptyDirVolume(res.release, podName, volume.Name, source.EmptyDir, mount))
+				}
+
+				// Handle hostPath volumes
+				if source.HostPath != nil {
+					app.Binds = append(app.Binds, hostPathBind(source.HostPath, mount))
+				}
+
+				// Handle projected and downwardAPI volumes, materialized as files
+				if source.Projected != nil {
+					mountVolumeFiles(&app, projectedFiles(source.Projected, pod, res), mount)
+				}
+				if source.DownwardAPI != nil {
+					mountVolumeFiles(&app, downwardAPIFiles(source.DownwardAPI.Items, pod), mount)
+				}
+
+				if sourceType := volumeSourceType(source); !supportedVolumeSources[sourceType] {
+					fmt.Printf("warning: volume %s of %s uses unsupported source %s, skipping\n", volume.Name, podName, sourceType)
+				}
+
+				// Handle PersistentVolumeClaim volumes
+				if claim := source.PersistentVolumeClaim; claim != nil {
+					var claimSpec *corev1.PersistentVolumeClaimSpec
+					if pvc, exists := res.claims[claim.ClaimName]; exists {
+						claimSpec = &pvc.Spec
 					}
+					claimVol := claimVolume(volumeName(res.release, claim.ClaimName), claim.ClaimName, claimSpec, mount)
+					claimVol.ReadOnly = claimVol.ReadOnly || claim.ReadOnly
+					app.Volumes = append(app.Volumes, claimVol)
 				}
 			}
 		}
@@ -556,38 +705,53 @@ func matchesSelector(labels map[string]string, selector map[string]string) bool
 	return true
 }
 
-func getStringFromMap(m map[string]interface{}, key string) string {
-	if value, exists := m[key]; exists {
-		if str, ok := value.(string); ok {
-			return str
-		}
+// configMapData returns the keys of a ConfigMap, binaryData included
+func configMapData(configMap *corev1.ConfigMap) map[string]string {
+	data := make(map[string]string, len(configMap.Data)+len(configMap.BinaryData))
+	for k, v := range configMap.BinaryData {
+		data[k] = string(v)
+	}
+	for k, v := range configMap.Data {
+		data[k] = v
 	}
-	return ""
+	return data
 }
 
-func getValueFromFieldPath(resource spec.Resource, fieldPath string) string {
-	parts := strings.Split(fieldPath, ".")
-	
-	var current interface{} = map[string]interface{}{
-		"metadata": resource.Metadata,
-		"spec":     resource.Spec,
+// secretData returns the decoded keys of a Secret
+func secretData(secret *corev1.Secret) map[string]string {
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
 	}
-	
-	for _, part := range parts {
-		if currentMap, ok := current.(map[string]interface{}); ok {
-			if value, exists := currentMap[part]; exists {
-				current = value
-			} else {
-				return ""
-			}
-		} else {
-			return ""
-		}
+	return data
+}
+
+// lookupSecretKey returns the decoded value of a key in a Secret, and whether the key was found
+func lookupSecretKey(secrets map[string]*corev1.Secret, secretName string, key string) (string, bool) {
+	secret, exists := secrets[secretName]
+	if !exists {
+		return "", false
 	}
-	
-	if str, ok := current.(string); ok {
-		return str
+	value, exists := secret.Data[key]
+	if !exists {
+		return "", false
 	}
-	
-	return fmt.Sprintf("%v", current)
+	return string(value), true
+}
+
+// mergeSecretStringData folds plain-text stringData into data,
+// the same way the API server does when a Secret is created
+func mergeSecretStringData(secret *corev1.Secret) *corev1.Secret {
+	if len(secret.StringData) == 0 {
+		return secret
+	}
+	merged := secret.DeepCopy()
+	if merged.Data == nil {
+		merged.Data = make(map[string][]byte, len(secret.StringData))
+	}
+	for k, v := range secret.StringData {
+		merged.Data[k] = []byte(v)
+	}
+	merged.StringData = nil
+	return merged
 }
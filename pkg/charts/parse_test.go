@@ -0,0 +1,322 @@
+package charts
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+)
+
+func TestScaledPortRange(t *testing.T) {
+	tests := []struct {
+		port     string
+		replicas int
+		want     string
+	}{
+		{"8080:80", 3, "8080-8082:80"},
+		{"53:5353/udp", 2, "53-54:5353/udp"},
+		{"8080:80", 1, "8080:80"},
+		{"8080:80", 0, "8080:80"},
+		{"80", 3, "80"},
+		{"http:80", 3, "http:80"},
+	}
+	for _, test := range tests {
+		if got := scaledPortRange(test.port, test.replicas); got != test.want {
+			t.Errorf("scaledPortRange(%q, %d) = %q, want %q", test.port, test.replicas, got, test.want)
+		}
+	}
+}
+
+func TestHostPortRange(t *testing.T) {
+	tests := []struct {
+		port        string
+		first, last int
+		protocol    string
+		ok          bool
+	}{
+		{"8080:80", 8080, 8080, "tcp", true},
+		{"8080-8082:80", 8080, 8082, "tcp", true},
+		{"53-54:5353/udp", 53, 54, "udp", true},
+		{"80", 0, 0, "", false},
+		{"http:80", 0, 0, "", false},
+	}
+	for _, test := range tests {
+		first, last, protocol, ok := hostPortRange(test.port)
+		if first != test.first || last != test.last || protocol != test.protocol || ok != test.ok {
+			t.Errorf("hostPortRange(%q) = %d, %d, %q, %v, want %d, %d, %q, %v", test.port, first, last, protocol, ok, test.first, test.last, test.protocol, test.ok)
+		}
+	}
+}
+
+func TestScalePodPorts(t *testing.T) {
+	podApps := []spec.App{
+		{Name: "web", Ports: []string{"30080:80", "30081:8080", "30090:9090", "30081:53/udp"}},
+		{Name: "side", Ports: []string{"30091:9091"}},
+	}
+	scalePodPorts(podApps, 3, "web")
+	want := [][]string{
+		{"30080-30082:80", "30090-30092:9090", "30081-30083:53/udp"},
+		{},
+	}
+	for i, app := range podApps {
+		if strings.Join(app.Ports, ",") != strings.Join(want[i], ",") {
+			t.Errorf("%s ports = %v, want %v", app.Name, app.Ports, want[i])
+		}
+	}
+}
+
+func TestTranslateReleaseNameCollisions(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: web
+          image: nginx
+          ports:
+            - containerPort: 8080
+        - name: proxy
+          image: envoy
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  selector:
+    app: web
+  ports:
+    - port: 80
+      targetPort: 8080
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+        - name: web
+          image: busybox
+          env:
+            - name: POD_IP
+              valueFrom:
+                fieldRef:
+                  fieldPath: status.podIP
+`
+	apps, err := translateRelease("rel", manifest, nil, nil)
+	if err != nil {
+		t.Fatalf("translateRelease: %v", err)
+	}
+	appsByName := make(map[string]spec.App)
+	for _, app := range apps {
+		if _, exists := appsByName[app.Name]; exists {
+			t.Errorf("service %s is generated twice", app.Name)
+		}
+		appsByName[app.Name] = app
+	}
+	if app, exists := appsByName["rel-web-proxy-service"]; !exists || app.Image != proxyImage {
+		t.Errorf("the proxy of Service web should be renamed to rel-web-proxy-service, got %v", appsByName["rel-web-proxy-service"])
+	}
+	if app, exists := appsByName["rel-web-proxy"]; !exists || app.Image != "envoy" {
+		t.Errorf("container proxy of Deployment web should keep rel-web-proxy")
+	}
+	job, exists := appsByName["rel-web-web-job"]
+	if !exists {
+		t.Fatalf("the container of Job web should be renamed to rel-web-web-job")
+	}
+	if got := job.Configs["POD_IP"]; got != "rel-web-web-job" {
+		t.Errorf("POD_IP = %q, want the renamed rel-web-web-job", got)
+	}
+}
+
+func TestTranslateReleaseHostServices(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: Service
+metadata:
+  name: db
+spec:
+  selector:
+    app: db
+  ports:
+    - port: 5432
+      targetPort: 15432
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+spec:
+  selector:
+    app: api
+  ports:
+    - port: 80
+      targetPort: 8080
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: db
+spec:
+  selector:
+    matchLabels:
+      app: db
+  template:
+    metadata:
+      labels:
+        app: db
+    spec:
+      containers:
+        - name: db
+          image: postgres
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  selector:
+    matchLabels:
+      app: api
+  template:
+    metadata:
+      labels:
+        app: api
+    spec:
+      containers:
+        - name: api
+          image: api
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: exporter
+spec:
+  selector:
+    matchLabels:
+      app: exporter
+  template:
+    metadata:
+      labels:
+        app: exporter
+    spec:
+      hostNetwork: true
+      containers:
+        - name: exporter
+          image: exporter
+          env:
+            - name: DB
+              value: db:5432
+            - name: API
+              value: api:80
+`
+	apps, err := translateRelease("rel", manifest, nil, []string{"deployment/db"})
+	if err != nil {
+		t.Fatalf("translateRelease: %v", err)
+	}
+	for _, app := range apps {
+		if app.Name == "rel-api-api" && !slices.Contains(app.ExtraHosts, "db.default.svc.cluster.local:host-gateway") {
+			t.Errorf("api should reach db through the host, extra hosts %v", app.ExtraHosts)
+		}
+	}
+	for _, app := range apps {
+		if app.Name != "rel-exporter-exporter" {
+			continue
+		}
+		if len(app.ExtraHosts) != 0 {
+			t.Errorf("host networked exporter got extra hosts %v", app.ExtraHosts)
+		}
+		// db runs on the host, api stays bridged behind a ClusterIP Service
+		if got := app.Configs["DB"]; got != "localhost:15432" {
+			t.Errorf("DB = %q, want localhost:15432", got)
+		}
+		if got := app.Configs["API"]; got != "api:80" {
+			t.Errorf("API = %q, want api:80", got)
+		}
+		return
+	}
+	t.Errorf("no rel-exporter-exporter app")
+}
+
+func TestReplicaPortsOf(t *testing.T) {
+	ports := []string{"8080-8082:80", "53-55:5353/udp", "9090:90", "80"}
+	want := []string{"8082:80", "55:5353/udp", "9092:90", "80"}
+	if got := replicaPortsOf(ports, 2); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("replicaPortsOf(%v, 2) = %v, want %v", ports, got, want)
+	}
+}
+
+func TestTranslateReleaseScaledSidecars(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: web
+          image: nginx
+          ports:
+            - containerPort: 80
+        - name: exporter
+          image: exporter
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: NodePort
+  selector:
+    app: web
+  ports:
+    - port: 80
+      nodePort: 30080
+`
+	apps, err := translateRelease("rel", manifest, nil, nil)
+	if err != nil {
+		t.Fatalf("translateRelease: %v", err)
+	}
+	appsByName := make(map[string]spec.App)
+	for _, app := range apps {
+		appsByName[app.Name] = app
+	}
+	// every replica is a pod of its own, with its sidecar
+	for ordinal, port := range []string{"30080:80", "30081:80"} {
+		main := appsByName[fmt.Sprintf("rel-web-%d-web", ordinal)]
+		sidecar := appsByName[fmt.Sprintf("rel-web-%d-exporter", ordinal)]
+		if main.Replicas != nil || sidecar.Replicas != nil {
+			t.Errorf("replica pod %d should not be scaled by compose", ordinal)
+		}
+		if strings.Join(main.Ports, ",") != port {
+			t.Errorf("replica pod %d publishes %v, want %s", ordinal, main.Ports, port)
+		}
+		if sidecar.NetworkMode != "service:"+main.Name {
+			t.Errorf("sidecar of replica pod %d: network_mode %q, want service:%s", ordinal, sidecar.NetworkMode, main.Name)
+		}
+	}
+	if len(apps) != 4 {
+		t.Errorf("got %d apps, want 4", len(apps))
+	}
+}
@@ -0,0 +1,338 @@
+package charts
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// podmanPod is a pod of the release written as a Kubernetes Pod manifest, which restart.sh runs with podman kube play
+type podmanPod struct {
+	name    string
+	file    string
+	network string                              // --network of podman kube play, the release network with the pod's DNS names
+	volumes map[string]spec.DockerComposeVolume // named volumes of the pod, created before it is played
+}
+
+// podmanPods splits off the multi-container pods of the release, run as Podman pods, from the apps written
+// to the compose project. Job pods are run by run-job.sh, host networked pods have no namespace to share,
+// and scaled pods are replicated by compose
+func podmanPods(apps []spec.App) ([]spec.App, [][]spec.App) {
+	var podNames []string
+	members := make(map[string][]int)
+	for i, app := range apps {
+		if app.Pod == "" {
+			continue
+		}
+		if _, exists := members[app.Pod]; !exists {
+			podNames = append(podNames, app.Pod)
+		}
+		members[app.Pod] = append(members[app.Pod], i)
+	}
+
+	inPod := make(map[int]bool)
+	var pods [][]spec.App
+	for _, podName := range podNames {
+		indexes := members[podName]
+		containers := 0
+		skip := false
+		for _, i := range indexes {
+			if apps[i].Job != nil || apps[i].NetworkMode == "host" || apps[i].Replicas != nil {
+				skip = true
+			}
+			if !apps[i].Init {
+				containers++
+			}
+		}
+		if skip || containers < 2 {
+			continue
+		}
+		var pod []spec.App
+		for _, i := range indexes {
+			inPod[i] = true
+			pod = append(pod, apps[i])
+		}
+		pods = append(pods, pod)
+	}
+
+	var composeApps []spec.App
+	for i, app := range apps {
+		if !inPod[i] {
+			composeApps = append(composeApps, app)
+		}
+	}
+	return composeApps, pods
+}
+
+// writePodmanPod writes the containers of a pod as a Kubernetes Pod manifest under releaseDir/pods,
+// their mounted files next to those of the compose project. Init containers run before the others,
+// every container shares the pod's network, IPC and UTS namespaces, and its PID namespace with shareProcessNamespace
+func writePodmanPod(members []spec.App, releaseDir string, name string) (podmanPod, error) {
+	podsDir := fmt.Sprintf("%s/pods", releaseDir)
+	if err := os.MkdirAll(podsDir, 0755); err != nil {
+		return podmanPod{}, fmt.Errorf("error creating pods directory: %v", err)
+	}
+	absReleaseDir, err := filepath.Abs(releaseDir)
+	if err != nil {
+		return podmanPod{}, fmt.Errorf("error resolving %s: %v", releaseDir, err)
+	}
+
+	podName := sanitizeName(releaseName(name, members[0].Pod))
+	labels := make(map[string]string)
+	for key, value := range members[0].Labels {
+		if key != labelPrefix+"container" {
+			labels[key] = value
+		}
+	}
+	pod := corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Labels: labels},
+		Spec:       corev1.PodSpec{RestartPolicy: corev1.RestartPolicyAlways},
+	}
+	// the containers share the pod's network namespace, the pod answers to all their DNS names
+	var aliases []string
+	hostAliases := make(map[string][]string)
+	podVolumes := make(map[string]string) // compose volume source -> pod volume name
+	dockerCompose := newDockerCompose(name)
+
+	for _, member := range members {
+		// composeService writes the mounted files and declares the named volumes, as for the compose project
+		service, err := composeService(member, releaseDir, member.Name, name, &dockerCompose)
+		if err != nil {
+			return podmanPod{}, err
+		}
+		containerName := member.Labels[labelPrefix+"container"]
+		if containerName == "" {
+			containerName = member.Name
+		}
+		container := corev1.Container{
+			Name:      containerName,
+			Image:     member.Image,
+			Args:      member.Command,
+			Ports:     podmanContainerPorts(member),
+			Resources: podmanResources(member),
+		}
+		keys := make([]string, 0, len(member.Configs))
+		for key := range member.Configs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			container.Env = append(container.Env, corev1.EnvVar{Name: key, Value: member.Configs[key]})
+		}
+		for _, volume := range service.Volumes {
+			source := volume.Source
+			if volume.Type == "bind" && !filepath.IsAbs(source) {
+				source = filepath.Join(absReleaseDir, source)
+			}
+			volumeName, exists := podVolumes[source]
+			if !exists {
+				volumeName = fmt.Sprintf("volume-%d", len(podVolumes))
+				podVolumes[source] = volumeName
+				pod.Spec.Volumes = append(pod.Spec.Volumes, podmanVolume(volumeName, source, volume))
+			}
+			mount := corev1.VolumeMount{Name: volumeName, MountPath: volume.Target, ReadOnly: volume.ReadOnly}
+			if volume.Volume != nil {
+				mount.SubPath = volume.Volume.Subpath
+			}
+			container.VolumeMounts = append(container.VolumeMounts, mount)
+		}
+		if member.Security != nil {
+			container.SecurityContext = podmanSecurityContext(member.Security, &pod.Spec)
+		}
+		if member.Healthcheck != nil {
+			probe := podmanProbe(member.Healthcheck)
+			if member.Healthcheck.Readiness {
+				container.ReadinessProbe = probe
+			} else {
+				container.LivenessProbe = probe
+			}
+		}
+
+		if member.Hostname != "" {
+			pod.Spec.Hostname = member.Hostname
+		}
+		pod.Spec.HostPID = pod.Spec.HostPID || member.Pid == "host"
+		pod.Spec.HostIPC = pod.Spec.HostIPC || member.Ipc == "host"
+		if member.ShareProcessNamespace {
+			shareProcessNamespace := true
+			pod.Spec.ShareProcessNamespace = &shareProcessNamespace
+		}
+		for _, extraHost := range member.ExtraHosts {
+			host, address, _ := strings.Cut(extraHost, ":")
+			if net.ParseIP(address) == nil {
+				fmt.Printf("warning: pod %s: podman kube play only takes addresses as host aliases, leaving out %s\n", podName, extraHost)
+				continue
+			}
+			if !slices.Contains(hostAliases[address], host) {
+				hostAliases[address] = append(hostAliases[address], host)
+			}
+		}
+
+		if member.Init {
+			pod.Spec.InitContainers = append(pod.Spec.InitContainers, container)
+			continue
+		}
+		pod.Spec.Containers = append(pod.Spec.Containers, container)
+		for _, alias := range append([]string{member.Name}, member.Aliases...) {
+			if !slices.Contains(aliases, alias) {
+				aliases = append(aliases, alias)
+			}
+		}
+	}
+
+	addresses := make([]string, 0, len(hostAliases))
+	for address := range hostAliases {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		pod.Spec.HostAliases = append(pod.Spec.HostAliases, corev1.HostAlias{IP: address, Hostnames: hostAliases[address]})
+	}
+
+	data, err := yaml.Marshal(pod)
+	if err != nil {
+		return podmanPod{}, fmt.Errorf("error marshaling pod %s: %v", podName, err)
+	}
+	podFile := fmt.Sprintf("%s/%s.yaml", podsDir, podName)
+	if err := os.WriteFile(podFile, data, 0644); err != nil {
+		return podmanPod{}, fmt.Errorf("error writing pod %s: %v", podName, err)
+	}
+
+	network := name
+	if len(aliases) > 0 {
+		network += ":alias=" + strings.Join(aliases, ",alias=")
+	}
+	return podmanPod{name: podName, file: podFile, network: network, volumes: dockerCompose.Volumes}, nil
+}
+
+// podmanContainerPorts turns the published ports of an app, host:container[/protocol], into container ports
+// with a hostPort, which podman kube play publishes on the pod
+func podmanContainerPorts(app spec.App) []corev1.ContainerPort {
+	var ports []corev1.ContainerPort
+	for _, mapping := range app.Ports {
+		mapping, protocol, _ := strings.Cut(mapping, "/")
+		hostPort, containerPort, found := strings.Cut(mapping, ":")
+		host, hostErr := strconv.Atoi(hostPort)
+		port, portErr := strconv.Atoi(containerPort)
+		if !found || hostErr != nil || portErr != nil {
+			fmt.Printf("warning: %s: cannot publish %s on a Podman pod, skipping it\n", app.Name, mapping)
+			continue
+		}
+		containerPortSpec := corev1.ContainerPort{ContainerPort: int32(port), HostPort: int32(host)}
+		if protocol != "" {
+			containerPortSpec.Protocol = corev1.Protocol(strings.ToUpper(protocol))
+		}
+		ports = append(ports, containerPortSpec)
+	}
+	return ports
+}
+
+// podmanVolume returns the pod volume of a compose service volume: a hostPath for bind mounts,
+// a claim for named volumes, which podman kube play maps to the volume of the same name
+func podmanVolume(name string, source string, volume spec.DockerComposeServiceVolume) corev1.Volume {
+	if volume.Type == "volume" {
+		return corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: source},
+		}}
+	}
+	hostPath := &corev1.HostPathVolumeSource{Path: source}
+	if volume.Bind != nil && volume.Bind.CreateHostPath {
+		hostPathType := corev1.HostPathDirectoryOrCreate
+		hostPath.Type = &hostPathType
+	}
+	return corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{HostPath: hostPath}}
+}
+
+// podmanResources returns the cpu and memory requests and limits of an app, GPUs are not passed to Podman pods
+func podmanResources(app spec.App) corev1.ResourceRequirements {
+	var requirements corev1.ResourceRequirements
+	if app.Resources == nil {
+		return requirements
+	}
+	requirements.Limits = make(corev1.ResourceList)
+	requirements.Requests = make(corev1.ResourceList)
+	if app.Resources.CPULimit > 0 {
+		requirements.Limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(app.Resources.CPULimit*1000), resource.DecimalSI)
+	}
+	if app.Resources.CPURequest > 0 {
+		requirements.Requests[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(app.Resources.CPURequest*1000), resource.DecimalSI)
+	}
+	if app.Resources.MemoryLimit > 0 {
+		requirements.Limits[corev1.ResourceMemory] = *resource.NewQuantity(app.Resources.MemoryLimit, resource.BinarySI)
+	}
+	if app.Resources.MemoryRequest > 0 {
+		requirements.Requests[corev1.ResourceMemory] = *resource.NewQuantity(app.Resources.MemoryRequest, resource.BinarySI)
+	}
+	if app.Resources.GPUs > 0 {
+		fmt.Printf("warning: %s: GPUs are not passed to containers of Podman pods\n", app.Name)
+	}
+	return requirements
+}
+
+// podmanSecurityContext returns the securityContext of an app's container, its supplementary groups go to the pod
+func podmanSecurityContext(security *spec.AppSecurity, podSpec *corev1.PodSpec) *corev1.SecurityContext {
+	context := &corev1.SecurityContext{}
+	if user, group, hasGroup := strings.Cut(security.User, ":"); user != "" {
+		if uid, err := strconv.ParseInt(user, 10, 64); err == nil {
+			context.RunAsUser = &uid
+		}
+		if gid, err := strconv.ParseInt(group, 10, 64); hasGroup && err == nil {
+			context.RunAsGroup = &gid
+		}
+	}
+	for _, group := range security.GroupAdd {
+		if gid, err := strconv.ParseInt(group, 10, 64); err == nil {
+			if podSpec.SecurityContext == nil {
+				podSpec.SecurityContext = &corev1.PodSecurityContext{}
+			}
+			podSpec.SecurityContext.SupplementalGroups = append(podSpec.SecurityContext.SupplementalGroups, gid)
+		}
+	}
+	if security.ReadOnly {
+		context.ReadOnlyRootFilesystem = &security.ReadOnly
+	}
+	if security.Privileged {
+		context.Privileged = &security.Privileged
+	}
+	if security.NoNewPrivileges {
+		allowPrivilegeEscalation := false
+		context.AllowPrivilegeEscalation = &allowPrivilegeEscalation
+	}
+	if len(security.CapAdd) > 0 || len(security.CapDrop) > 0 {
+		context.Capabilities = &corev1.Capabilities{}
+		for _, capability := range security.CapAdd {
+			context.Capabilities.Add = append(context.Capabilities.Add, corev1.Capability(capability))
+		}
+		for _, capability := range security.CapDrop {
+			context.Capabilities.Drop = append(context.Capabilities.Drop, corev1.Capability(capability))
+		}
+	}
+	return context
+}
+
+// podmanProbe turns a healthcheck back into an exec probe, which podman kube play runs as the container's healthcheck
+func podmanProbe(healthcheck *spec.AppHealthcheck) *corev1.Probe {
+	command := healthcheck.Test[1:]
+	if healthcheck.Test[0] == "CMD-SHELL" {
+		command = []string{"/bin/sh", "-c", strings.Join(healthcheck.Test[1:], " ")}
+	}
+	return &corev1.Probe{
+		ProbeHandler:        corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: command}},
+		InitialDelaySeconds: int32(healthcheck.StartPeriod),
+		PeriodSeconds:       int32(healthcheck.Interval),
+		TimeoutSeconds:      int32(healthcheck.Timeout),
+		FailureThreshold:    int32(healthcheck.Retries),
+	}
+}
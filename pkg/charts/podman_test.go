@@ -0,0 +1,136 @@
+package charts
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ashupednekar/compose/pkg"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestWriteComposePodmanPods(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      shareProcessNamespace: true
+      initContainers:
+        - name: migrate
+          image: migrate
+      containers:
+        - name: web
+          image: nginx
+          env:
+            - name: MODE
+              value: $production
+          ports:
+            - containerPort: 80
+        - name: exporter
+          image: exporter
+          ports:
+            - containerPort: 9100
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  selector:
+    matchLabels:
+      app: api
+  template:
+    metadata:
+      labels:
+        app: api
+    spec:
+      containers:
+        - name: api
+          image: api
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: NodePort
+  selector:
+    app: web
+  ports:
+    - port: 80
+      nodePort: 30080
+`
+	apps, err := translateRelease("rel", manifest, nil, nil)
+	if err != nil {
+		t.Fatalf("translateRelease: %v", err)
+	}
+	settings := pkg.Settings
+	defer func() { pkg.Settings = settings }()
+	pkg.Settings = &pkg.ComposeConf{ManifestDir: t.TempDir()}
+	if err := WriteCompose(apps, "rel", LayoutRelease, EnginePodman, false); err != nil {
+		t.Fatalf("WriteCompose: %v", err)
+	}
+	releaseDir := pkg.Settings.ManifestDir + "/rel"
+
+	// single container pods stay in the compose project
+	compose, err := os.ReadFile(releaseDir + "/docker-compose.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(compose), "rel-api-api:") || strings.Contains(string(compose), "rel-web-") {
+		t.Errorf("compose project should only hold rel-api-api:\n%s", compose)
+	}
+
+	data, err := os.ReadFile(releaseDir + "/pods/rel-web.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pod corev1.Pod
+	if err := yaml.Unmarshal(data, &pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Name != "rel-web" || pod.Labels[labelPrefix+"release"] != "rel" {
+		t.Errorf("pod metadata = %+v", pod.ObjectMeta)
+	}
+	if len(pod.Spec.InitContainers) != 1 || pod.Spec.InitContainers[0].Name != "migrate" {
+		t.Errorf("init containers = %+v", pod.Spec.InitContainers)
+	}
+	if len(pod.Spec.Containers) != 2 || pod.Spec.Containers[0].Name != "web" || pod.Spec.Containers[1].Name != "exporter" {
+		t.Fatalf("containers = %+v", pod.Spec.Containers)
+	}
+	web := pod.Spec.Containers[0]
+	if len(web.Ports) != 1 || web.Ports[0].HostPort != 30080 || web.Ports[0].ContainerPort != 80 {
+		t.Errorf("web ports = %+v, want 30080:80", web.Ports)
+	}
+	// values are not interpolated by podman kube play, unlike compose
+	if len(web.Env) != 1 || web.Env[0].Value != "$production" {
+		t.Errorf("web env = %+v", web.Env)
+	}
+	if pod.Spec.ShareProcessNamespace == nil || !*pod.Spec.ShareProcessNamespace {
+		t.Errorf("shareProcessNamespace is not set on the pod")
+	}
+
+	script, err := os.ReadFile(releaseDir + "/restart.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"$engine pod ls -q --filter label=" + labelPrefix + "release=rel",
+		`play_pod "` + releaseDir + `/pods/rel-web.yaml" 'rel:alias=rel-web-web,alias=web,`,
+		"alias=rel-web-exporter'",
+	} {
+		if !strings.Contains(string(script), want) {
+			t.Errorf("restart.sh is missing %q:\n%s", want, script)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package charts
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+)
+
+// podInfraImage is the pause image holding a pod's namespaces, the one the kubelet uses for its infra containers
+const podInfraImage = "registry.k8s.io/pause:3.10"
+
+// joinNetworkOwners moves the published ports and DNS names of the sidecars sharing another container's network
+// namespace onto it, compose sets neither on a service with network_mode. With the app layout sidecars have
+// namespaces of their own, and keep theirs
+func joinNetworkOwners(apps []spec.App) []spec.App {
+	owners := make(map[string]int)
+	for i, app := range apps {
+		owners[app.Name] = i
+	}
+	joined := append([]spec.App{}, apps...)
+	for i := range joined {
+		target, isService := strings.CutPrefix(joined[i].NetworkMode, "service:")
+		owner, exists := owners[target]
+		if !isService || !exists || joined[i].Compose != nil {
+			continue
+		}
+		ownerApp := &joined[owner]
+		ownerApp.Ports = append(slices.Clone(ownerApp.Ports), joined[i].Ports...)
+		aliases := slices.Clone(ownerApp.Aliases)
+		for _, alias := range joined[i].Aliases {
+			if !slices.Contains(aliases, alias) {
+				aliases = append(aliases, alias)
+			}
+		}
+		ownerApp.Aliases = aliases
+		joined[i].Ports = []string{}
+		joined[i].Aliases = nil
+	}
+	return joined
+}
+
+// podInfraContainers groups the containers of each multi-container pod around an infra container, the way the
+// kubelet does. The infra container owns the pod's network and IPC namespaces, and its PID
+// namespace with shareProcessNamespace, so they outlive restarts of any container of the pod. It also
+// takes over the pod's published ports, hostname and DNS names, which cannot be set on containers joining it,
+// and answers to the service names of the pod's containers.
+// Job pods keep sharing the main container's namespaces, an infra container would outlive their run,
+// and host networked pods have no namespace to share
+func podInfraContainers(apps []spec.App) []spec.App {
+	var podNames []string
+	members := make(map[string][]int)
+	taken := make(map[string]bool)
+	for i, app := range apps {
+		taken[app.Name] = true
+		if app.Pod == "" {
+			continue
+		}
+		if _, exists := members[app.Pod]; !exists {
+			podNames = append(podNames, app.Pod)
+		}
+		members[app.Pod] = append(members[app.Pod], i)
+	}
+
+	infras := make(map[int]spec.App) // index of the main container -> infra app written before it
+	for _, podName := range podNames {
+		indexes := members[podName]
+		if len(indexes) < 2 {
+			continue
+		}
+		main := -1
+		skip := false
+		for _, i := range indexes {
+			if apps[i].Job != nil || apps[i].NetworkMode == "host" {
+				skip = true
+			}
+			if main == -1 && !apps[i].Init {
+				main = i
+			}
+		}
+		if skip || main == -1 {
+			continue
+		}
+
+		mainApp := &apps[main]
+		labels := make(map[string]string)
+		for key, value := range mainApp.Labels {
+			if key != labelPrefix+"container" {
+				labels[key] = value
+			}
+		}
+		// a container called infra already has the name
+		infraName := appName(labels[labelPrefix+"release"], podName, "infra")
+		if taken[infraName] {
+			renamed := freeName(infraName, "pod", func(candidate string) bool { return taken[candidate] })
+			fmt.Printf("warning: pod %s: service %s already exists, naming its infra container %s\n", podName, infraName, renamed)
+			infraName = renamed
+		}
+		taken[infraName] = true
+		infra := spec.App{
+			Name:       infraName,
+			Type:       mainApp.Type,
+			Image:      podInfraImage,
+			Configs:    make(map[string]string),
+			Mounts:     make(map[string]string),
+			Ports:      mainApp.Ports,
+			Replicas:   mainApp.Replicas,
+			Hostname:   mainApp.Hostname,
+			Domainname: mainApp.Domainname,
+			Aliases:    mainApp.Aliases,
+			ExtraHosts: mainApp.ExtraHosts,
+			Ipc:        "shareable",
+			Pod:        podName,
+			Labels:     labels,
+		}
+		mainApp.Ports = []string{}
+		mainApp.Hostname = ""
+		mainApp.Domainname = ""
+		mainApp.Aliases = nil
+		mainApp.ExtraHosts = nil
+
+		for _, i := range indexes {
+			member := &apps[i]
+			// the pod's containers keep resolving to it by their service names
+			if !member.Init {
+				infra.Aliases = append(infra.Aliases, member.Name)
+			}
+			member.NetworkMode = fmt.Sprintf("service:%s", infra.Name)
+			if member.Ipc == "" {
+				member.Ipc = fmt.Sprintf("service:%s", infra.Name)
+			}
+			if member.ShareProcessNamespace && member.Pid == "" {
+				member.Pid = fmt.Sprintf("service:%s", infra.Name)
+			}
+			if member.DependsOn == nil {
+				member.DependsOn = make(map[string]string)
+			}
+			member.DependsOn[infra.Name] = "service_started"
+		}
+		infras[main] = infra
+	}
+
+	grouped := make([]spec.App, 0, len(apps)+len(infras))
+	for i, app := range apps {
+		if infra, exists := infras[i]; exists {
+			grouped = append(grouped, infra)
+		}
+		grouped = append(grouped, app)
+	}
+	return grouped
+}
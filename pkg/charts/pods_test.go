@@ -0,0 +1,112 @@
+package charts
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+)
+
+func TestPodInfraContainersName(t *testing.T) {
+	labels := map[string]string{labelPrefix + "release": "rel"}
+	apps := []spec.App{
+		{Name: "rel-web-web", Pod: "web", Labels: labels},
+		{Name: "rel-web-infra", Pod: "web", Labels: labels},
+		{Name: "rel-web-infra-pod", Pod: "other", Labels: labels},
+	}
+	grouped := podInfraContainers(apps)
+	if len(grouped) != 4 {
+		t.Fatalf("got %d apps, want 4", len(grouped))
+	}
+	infra := grouped[0]
+	if infra.Image != podInfraImage || infra.Name != "rel-web-infra-pod-2" {
+		t.Errorf("infra container = %s (%s), want rel-web-infra-pod-2", infra.Name, infra.Image)
+	}
+	for _, member := range grouped[1:3] {
+		if member.NetworkMode != "service:rel-web-infra-pod-2" {
+			t.Errorf("%s: network_mode = %q, want service:rel-web-infra-pod-2", member.Name, member.NetworkMode)
+		}
+	}
+}
+
+func TestSidecarPortsAndEndpoints(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: web
+          image: nginx
+          ports:
+            - containerPort: 8080
+        - name: exporter
+          image: exporter
+          ports:
+            - name: metrics
+              containerPort: 9100
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: metrics
+spec:
+  type: NodePort
+  selector:
+    app: web
+  ports:
+    - port: 9101
+      targetPort: metrics
+      nodePort: 30100
+`
+	apps, err := translateRelease("rel", manifest, nil, nil)
+	if err != nil {
+		t.Fatalf("translateRelease: %v", err)
+	}
+	appsByName := make(map[string]spec.App)
+	for _, app := range apps {
+		appsByName[app.Name] = app
+	}
+	// the sidecar listens for the Service, the app layout publishes its port and proxies to it
+	sidecar := appsByName["rel-web-exporter"]
+	if !slices.Equal(sidecar.Ports, []string{"30100:9100"}) || !slices.Equal(sidecar.Endpoints["metrics"], []int{9100}) {
+		t.Errorf("sidecar ports = %v, endpoints = %v", sidecar.Ports, sidecar.Endpoints)
+	}
+	if !slices.Contains(sidecar.Aliases, "rel-web-exporter.metrics.endpoints") {
+		t.Errorf("sidecar aliases = %v, want the proxy's endpoint name", sidecar.Aliases)
+	}
+	proxy := appsByName["rel-metrics-proxy"]
+	if config := proxy.Mounts[proxyConfigTemplate]; !strings.Contains(config, "server rel-web-exporter.metrics.endpoints:9100 resolve;") {
+		t.Errorf("proxy config doesn't reach the sidecar:\n%s", config)
+	}
+	main := appsByName["rel-web-web"]
+	if len(main.Ports) != 0 || slices.Contains(main.Aliases, "rel-web-exporter.metrics.endpoints") {
+		t.Errorf("main container ports = %v, aliases = %v", main.Ports, main.Aliases)
+	}
+
+	// the release layout publishes them on the main container, whose network namespace the sidecar shares
+	for _, app := range joinNetworkOwners(apps) {
+		switch app.Name {
+		case "rel-web-web":
+			if !slices.Equal(app.Ports, []string{"30100:9100"}) || !slices.Contains(app.Aliases, "rel-web-exporter.metrics.endpoints") {
+				t.Errorf("main container ports = %v, aliases = %v", app.Ports, app.Aliases)
+			}
+		case "rel-web-exporter":
+			if len(app.Ports) != 0 || len(app.Aliases) != 0 {
+				t.Errorf("sidecar ports = %v, aliases = %v", app.Ports, app.Aliases)
+			}
+		}
+	}
+	if len(sidecar.Ports) != 1 {
+		t.Errorf("joinNetworkOwners changed the apps it was given")
+	}
+}
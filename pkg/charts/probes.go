@@ -0,0 +1,143 @@
+package charts
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// containerHealthcheck translates a container's probes into a single compose healthcheck.
+// The readinessProbe is preferred, since it is what dependents wait on, then the livenessProbe.
+// A startupProbe only widens the start period, the way it holds off the other probes in Kubernetes
+func containerHealthcheck(container corev1.Container) *spec.AppHealthcheck {
+	probe, readiness := container.ReadinessProbe, container.ReadinessProbe != nil
+	if !readiness {
+		probe = container.LivenessProbe
+	}
+	if probe == nil {
+		return nil
+	}
+
+	test, err := probeTest(probe, container)
+	if err != nil {
+		fmt.Printf("warning: container %s: probe skipped, %v\n", container.Name, err)
+		return nil
+	}
+
+	healthcheck := &spec.AppHealthcheck{
+		Test:        test,
+		Interval:    probeSetting(probe.PeriodSeconds, 10),
+		Timeout:     probeSetting(probe.TimeoutSeconds, 1),
+		Retries:     probeSetting(probe.FailureThreshold, 3),
+		StartPeriod: int(probe.InitialDelaySeconds),
+		Readiness:   readiness,
+	}
+	if startup := container.StartupProbe; startup != nil {
+		healthcheck.StartPeriod = int(startup.InitialDelaySeconds) +
+			probeSetting(startup.FailureThreshold, 3)*probeSetting(startup.PeriodSeconds, 10)
+	}
+	return healthcheck
+}
+
+// probeSetting returns a probe setting, or its Kubernetes default when unset
+func probeSetting(value int32, defaultValue int) int {
+	if value == 0 {
+		return defaultValue
+	}
+	return int(value)
+}
+
+// probeTest returns the healthcheck test of an exec, httpGet, tcpSocket or grpc probe.
+// Network probes run inside the container, so they rely on wget/curl, nc or grpc_health_probe being in the image
+func probeTest(probe *corev1.Probe, container corev1.Container) ([]string, error) {
+	if exec := probe.Exec; exec != nil {
+		if len(exec.Command) == 0 {
+			return nil, fmt.Errorf("exec probe without a command")
+		}
+		return append([]string{"CMD"}, exec.Command...), nil
+	}
+
+	if httpGet := probe.HTTPGet; httpGet != nil {
+		port, err := probePort(httpGet.Port, container)
+		if err != nil {
+			return nil, err
+		}
+		host := httpGet.Host
+		if host == "" {
+			host = "localhost"
+		}
+		path := httpGet.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		scheme := strings.ToLower(string(httpGet.Scheme))
+		if scheme == "" {
+			scheme = "http"
+		}
+		url := fmt.Sprintf("%s://%s:%d%s", scheme, host, port, path)
+
+		var wgetHeaders, curlHeaders string
+		for _, header := range httpGet.HTTPHeaders {
+			value := fmt.Sprintf("%s: %s", header.Name, header.Value)
+			wgetHeaders += fmt.Sprintf(" --header %s", shellQuote(value))
+			curlHeaders += fmt.Sprintf(" -H %s", shellQuote(value))
+		}
+		return []string{"CMD-SHELL", fmt.Sprintf(
+			"wget -q --no-check-certificate -O /dev/null%[1]s %[3]s || curl -fsSk -o /dev/null%[2]s %[3]s",
+			wgetHeaders, curlHeaders, shellQuote(url),
+		)}, nil
+	}
+
+	if tcpSocket := probe.TCPSocket; tcpSocket != nil {
+		port, err := probePort(tcpSocket.Port, container)
+		if err != nil {
+			return nil, err
+		}
+		host := tcpSocket.Host
+		if host == "" {
+			host = "localhost"
+		}
+		return []string{"CMD-SHELL", fmt.Sprintf(
+			"nc -z %[1]s %[2]d || bash -c '</dev/tcp/%[1]s/%[2]d'", host, port,
+		)}, nil
+	}
+
+	if grpc := probe.GRPC; grpc != nil {
+		if grpc.Port == 0 {
+			return nil, fmt.Errorf("grpc probe without a port")
+		}
+		test := []string{"CMD", "grpc_health_probe", fmt.Sprintf("-addr=localhost:%d", grpc.Port)}
+		if grpc.Service != nil && *grpc.Service != "" {
+			test = append(test, fmt.Sprintf("-service=%s", *grpc.Service))
+		}
+		return test, nil
+	}
+
+	return nil, fmt.Errorf("unsupported probe handler")
+}
+
+// probePort resolves the port of an httpGet or tcpSocket probe, looking up named ports in the container's ports
+func probePort(port intstr.IntOrString, container corev1.Container) (int, error) {
+	if port.Type == intstr.Int {
+		if port.IntVal == 0 {
+			return 0, fmt.Errorf("probe without a port")
+		}
+		return int(port.IntVal), nil
+	}
+	if number, found := namedContainerPort(container, port.StrVal); found {
+		return number, nil
+	}
+	if number, err := strconv.Atoi(port.StrVal); err == nil {
+		return number, nil
+	}
+	return 0, fmt.Errorf("named port %s not found in the container's ports", port.StrVal)
+}
+
+// shellQuote single-quotes a value for use in a CMD-SHELL healthcheck
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,95 @@
+package charts
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// gpuResource is the extended resource the NVIDIA device plugin advertises, mapped onto a compose device reservation
+const gpuResource = corev1.ResourceName("nvidia.com/gpu")
+
+// containerResources reads a container's resources.requests and resources.limits.
+// Only cpu, memory and NVIDIA GPUs have a compose equivalent, other resources are reported and ignored
+func containerResources(container corev1.Container) *spec.AppResources {
+	appResources := &spec.AppResources{}
+	found := false
+	for _, section := range []string{"limits", "requests"} {
+		quantities := container.Resources.Limits
+		if section == "requests" {
+			quantities = container.Resources.Requests
+		}
+		// sorted, so warnings come out in a stable order
+		var names []string
+		for resourceName := range quantities {
+			names = append(names, string(resourceName))
+		}
+		sort.Strings(names)
+		isLimit := section == "limits"
+		for _, name := range names {
+			quantity := quantities[corev1.ResourceName(name)]
+			switch corev1.ResourceName(name) {
+			case corev1.ResourceCPU:
+				cpus := float64(quantity.MilliValue()) / 1000
+				if isLimit {
+					appResources.CPULimit = cpus
+				} else {
+					appResources.CPURequest = cpus
+				}
+			case corev1.ResourceMemory:
+				if isLimit {
+					appResources.MemoryLimit = quantity.Value()
+				} else {
+					appResources.MemoryRequest = quantity.Value()
+				}
+			case gpuResource:
+				// extended resources must have equal requests and limits, either one will do
+				appResources.GPUs = int(quantity.Value())
+			default:
+				fmt.Printf("warning: container %s: %s %s cannot be mapped to compose, ignoring it\n", container.Name, name, section)
+				continue
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return appResources
+}
+
+// composeResources renders an app's resources as deploy.resources limits and reservations
+func composeResources(appResources *spec.AppResources) *spec.DockerComposeResources {
+	resources := &spec.DockerComposeResources{}
+	if appResources.CPULimit > 0 || appResources.MemoryLimit > 0 {
+		resources.Limits = &spec.DockerComposeResourceSpec{
+			Cpus:   formatCpus(appResources.CPULimit),
+			Memory: appResources.MemoryLimit,
+		}
+	}
+	if appResources.CPURequest > 0 || appResources.MemoryRequest > 0 || appResources.GPUs > 0 {
+		resources.Reservations = &spec.DockerComposeResourceSpec{
+			Cpus:   formatCpus(appResources.CPURequest),
+			Memory: appResources.MemoryRequest,
+		}
+		if appResources.GPUs > 0 {
+			resources.Reservations.Devices = []spec.DockerComposeDevice{{
+				Driver:       "nvidia",
+				Count:        appResources.GPUs,
+				Capabilities: []string{"gpu"},
+			}}
+		}
+	}
+	return resources
+}
+
+// formatCpus formats a cpu count the way compose expects it, empty for none
+func formatCpus(cpus float64) string {
+	if cpus <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(cpus, 'f', -1, 64)
+}
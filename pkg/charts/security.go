@@ -0,0 +1,68 @@
+package charts
+
+import (
+	"fmt"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// containerSecurity merges the pod and container securityContext the way Kubernetes does:
+// runAsUser and runAsGroup on the container win over the pod's, fsGroup and supplementalGroups
+// only exist on the pod, and the remaining settings only on the container
+func containerSecurity(podSpec corev1.PodSpec, container corev1.Container) *spec.AppSecurity {
+	podContext := podSpec.SecurityContext
+	containerContext := container.SecurityContext
+	if podContext == nil && containerContext == nil {
+		return nil
+	}
+	if podContext == nil {
+		podContext = &corev1.PodSecurityContext{}
+	}
+	if containerContext == nil {
+		containerContext = &corev1.SecurityContext{}
+	}
+
+	// container level settings override the pod level ones
+	user, group := podContext.RunAsUser, podContext.RunAsGroup
+	if containerContext.RunAsUser != nil {
+		user = containerContext.RunAsUser
+	}
+	if containerContext.RunAsGroup != nil {
+		group = containerContext.RunAsGroup
+	}
+
+	security := &spec.AppSecurity{}
+	switch {
+	case user != nil && group != nil:
+		security.User = fmt.Sprintf("%d:%d", *user, *group)
+	case user != nil:
+		security.User = fmt.Sprintf("%d", *user)
+	case group != nil:
+		// compose cannot set a primary group without a user, the image's user keeps its own
+		fmt.Printf("warning: container %s: runAsGroup %d without runAsUser, adding it as a supplementary group\n", container.Name, *group)
+		security.GroupAdd = append(security.GroupAdd, fmt.Sprintf("%d", *group))
+	}
+	if podContext.FSGroup != nil {
+		security.GroupAdd = append(security.GroupAdd, fmt.Sprintf("%d", *podContext.FSGroup))
+	}
+	for _, supplementalGroup := range podContext.SupplementalGroups {
+		security.GroupAdd = append(security.GroupAdd, fmt.Sprintf("%d", supplementalGroup))
+	}
+
+	security.ReadOnly = containerContext.ReadOnlyRootFilesystem != nil && *containerContext.ReadOnlyRootFilesystem
+	security.Privileged = containerContext.Privileged != nil && *containerContext.Privileged
+	// privilege escalation is allowed unless explicitly turned off
+	if allowed := containerContext.AllowPrivilegeEscalation; allowed != nil && !*allowed {
+		security.NoNewPrivileges = true
+	}
+	if capabilities := containerContext.Capabilities; capabilities != nil {
+		for _, capability := range capabilities.Add {
+			security.CapAdd = append(security.CapAdd, string(capability))
+		}
+		for _, capability := range capabilities.Drop {
+			security.CapDrop = append(security.CapDrop, string(capability))
+		}
+	}
+	return security
+}
@@ -0,0 +1,286 @@
+package charts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// publishedPorts returns the compose port mappings of the Services selecting a pod, for each of its containers.
+// ClusterIP Services are only reachable from the release network and publish nothing, NodePort
+// Services publish on their nodePort and LoadBalancer Services on their port, both to the targetPort.
+// Every matching Service is published, each port on the container declaring it, and mappings
+// two Services share are published once
+func publishedPorts(services map[string]spec.ServiceInfo, labels map[string]string, containers []corev1.Container, podName string) [][]string {
+	ports := make([][]string, len(containers))
+	for i := range ports {
+		ports[i] = []string{}
+	}
+	published := make(map[string]string) // host port and protocol -> mapping
+	for _, serviceInfo := range matchingServices(services, labels) {
+		if serviceInfo.Type != "NodePort" && serviceInfo.Type != "LoadBalancer" {
+			continue
+		}
+		for _, portInfo := range serviceInfo.Ports {
+			containerPort, owner, err := resolveTargetPort(portInfo, containers)
+			if err != nil {
+				fmt.Printf("warning: service %s: %v in pod %s, not publishing it\n", serviceInfo.Name, err, podName)
+				continue
+			}
+			hostPort := portInfo.Port
+			if serviceInfo.Type == "NodePort" && portInfo.NodePort != 0 {
+				hostPort = portInfo.NodePort
+			}
+			mapping := fmt.Sprintf("%d:%d", hostPort, containerPort)
+			protocol := strings.ToLower(portInfo.Protocol)
+			if protocol == "udp" || protocol == "sctp" {
+				mapping += "/" + protocol
+			}
+			hostKey := fmt.Sprintf("%d/%s", hostPort, protocol)
+			if existing, exists := published[hostKey]; exists {
+				if existing != mapping {
+					fmt.Printf("warning: service %s: host port %s is already published as %s, not publishing %s\n", serviceInfo.Name, hostKey, existing, mapping)
+				}
+				continue
+			}
+			published[hostKey] = mapping
+			ports[owner] = append(ports[owner], mapping)
+		}
+	}
+	return ports
+}
+
+// matchingServices returns the Services selecting a pod, sorted by name
+func matchingServices(services map[string]spec.ServiceInfo, labels map[string]string) []spec.ServiceInfo {
+	var matching []spec.ServiceInfo
+	for _, serviceInfo := range services {
+		if matchesSelector(labels, serviceInfo.Selector) {
+			matching = append(matching, serviceInfo)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Name < matching[j].Name })
+	return matching
+}
+
+// resolveTargetPort returns the container port a Service port forwards to, and the index of the container
+// declaring it. Named targetPorts are looked up in the containers' ports, numeric targetPorts no container
+// declares belong to the first container, since declaring container ports is optional
+func resolveTargetPort(portInfo spec.PortInfo, containers []corev1.Container) (int, int, error) {
+	for i, container := range containers {
+		if portInfo.TargetPortName != "" {
+			if port, found := namedContainerPort(container, portInfo.TargetPortName); found {
+				return port, i, nil
+			}
+			continue
+		}
+		for _, containerPort := range container.Ports {
+			if int(containerPort.ContainerPort) == portInfo.TargetPort {
+				return portInfo.TargetPort, i, nil
+			}
+		}
+	}
+	if portInfo.TargetPortName != "" {
+		return 0, 0, fmt.Errorf("targetPort %s is not a named containerPort", portInfo.TargetPortName)
+	}
+	return portInfo.TargetPort, 0, nil
+}
+
+// namedContainerPort looks up the number of a named port in a container's ports
+func namedContainerPort(container corev1.Container, name string) (int, bool) {
+	for _, containerPort := range container.Ports {
+		if containerPort.Name == name {
+			return int(containerPort.ContainerPort), true
+		}
+	}
+	return 0, false
+}
+
+// serviceAliases returns the DNS names of the Services selecting a pod that container of it listens for,
+// those whose ports all go to it. The first container answers for Services spread over several containers
+func serviceAliases(services map[string]spec.ServiceInfo, labels map[string]string, containers []corev1.Container, container int) []string {
+	var aliases []string
+	for _, serviceInfo := range services {
+		if matchesSelector(labels, serviceInfo.Selector) && serviceContainer(serviceInfo, containers) == container {
+			aliases = append(aliases, serviceDNSNames(serviceInfo)...)
+		}
+	}
+	// services is a map, keep the generated file stable across syncs
+	sort.Strings(aliases)
+	return aliases
+}
+
+// serviceContainer returns the index of the container every port of a Service goes to, 0 if they go to several
+func serviceContainer(serviceInfo spec.ServiceInfo, containers []corev1.Container) int {
+	owner := -1
+	for _, portInfo := range serviceInfo.Ports {
+		if _, i, err := resolveTargetPort(portInfo, containers); err == nil {
+			if owner != -1 && owner != i {
+				return 0
+			}
+			owner = i
+		}
+	}
+	return max(owner, 0)
+}
+
+// serviceDNSNames returns the names cluster DNS resolves a Service by:
+// <svc>, <svc>.<ns>, <svc>.<ns>.svc and <svc>.<ns>.svc.cluster.local
+func serviceDNSNames(serviceInfo spec.ServiceInfo) []string {
+	return []string{
+		serviceInfo.Name,
+		fmt.Sprintf("%s.%s", serviceInfo.Name, serviceInfo.Namespace),
+		fmt.Sprintf("%s.%s.svc", serviceInfo.Name, serviceInfo.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", serviceInfo.Name, serviceInfo.Namespace),
+	}
+}
+
+// serviceEndpoints resolves the container port behind each port of the Services selecting a pod, 0 for those
+// another container of the pod listens on. Sidecars are endpoints of the Services they listen for only
+func serviceEndpoints(services map[string]spec.ServiceInfo, labels map[string]string, containers []corev1.Container, container int) map[string][]int {
+	endpoints := make(map[string][]int)
+	for name, serviceInfo := range services {
+		if !matchesSelector(labels, serviceInfo.Selector) {
+			continue
+		}
+		ports := make([]int, len(serviceInfo.Ports))
+		listens := container == 0
+		for j, portInfo := range serviceInfo.Ports {
+			// unresolved ports are reported when publishing, and left out of proxies
+			containerPort, i, _ := resolveTargetPort(portInfo, containers)
+			if i == container {
+				ports[j] = containerPort
+				listens = listens || containerPort != 0
+			}
+		}
+		if listens {
+			endpoints[name] = ports
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+	return endpoints
+}
+
+// serviceProxies returns an nginx proxy app for every Service whose port differs from the container port
+// it forwards to. The proxy takes over the Service's DNS names from its backends, listens on the Service
+// ports and balances over all backends, every replica of a scaled service included. Headless Services
+// resolve to their pods, without any port translation, and keep their names on the backends
+func serviceProxies(apps []spec.App, services map[string]spec.ServiceInfo, release string) []spec.App {
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var proxies []spec.App
+	for _, name := range names {
+		serviceInfo := services[name]
+		if serviceInfo.ClusterIP == corev1.ClusterIPNone {
+			continue
+		}
+		var backends []*spec.App
+		needsProxy := false
+		for i := range apps {
+			ports, selected := apps[i].Endpoints[name]
+			// Jobs are not long running backends, and host networked apps have no name on the release network
+			if !selected || apps[i].Job != nil || apps[i].NetworkMode == "host" {
+				continue
+			}
+			backends = append(backends, &apps[i])
+			for j, containerPort := range ports {
+				if containerPort != 0 && containerPort != serviceInfo.Ports[j].Port {
+					needsProxy = true
+				}
+			}
+		}
+		if !needsProxy {
+			continue
+		}
+
+		dnsNames := make(map[string]bool)
+		for _, dnsName := range serviceDNSNames(serviceInfo) {
+			dnsNames[dnsName] = true
+		}
+		for _, backend := range backends {
+			var aliases []string
+			for _, alias := range backend.Aliases {
+				if !dnsNames[alias] {
+					aliases = append(aliases, alias)
+				}
+			}
+			// the backend's own name may be one of the Service's, the proxy reaches it through a name of its own
+			backend.Aliases = append(aliases, endpointAlias(serviceInfo, backend))
+		}
+
+		proxies = append(proxies, spec.App{
+			Name:    appName(release, name, "proxy"),
+			Type:    "Service",
+			Image:   proxyImage,
+			Command: []string{"/bin/sh", "-c", proxyStartScript},
+			Configs: make(map[string]string),
+			Mounts: map[string]string{
+				proxyConfigTemplate: proxyConfig(serviceInfo, backends),
+			},
+			Ports:   []string{},
+			Aliases: serviceDNSNames(serviceInfo),
+			Labels: map[string]string{
+				labelPrefix + "release": release,
+				labelPrefix + "service": name,
+			},
+		})
+	}
+	return proxies
+}
+
+// proxyImage is the nginx release the Service proxies run, 1.27.3 being the first to re-resolve upstreams
+const proxyImage = "nginx:1.28-alpine"
+
+// proxyConfigTemplate is where a Service proxy's config is mounted, proxyStartScript fills in its resolver
+const proxyConfigTemplate = "/etc/nginx/nginx.conf.template"
+
+// proxyStartScript starts nginx with the container's own nameserver as resolver: Docker's embedded DNS
+// at 127.0.0.11, the network gateway with Podman's aardvark-dns
+const proxyStartScript = `resolver=$(awk '/^nameserver/ { print ($2 ~ /:/) ? "[" $2 "]" : $2; exit }' /etc/resolv.conf)
+sed "s/@RESOLVER@/${resolver:-127.0.0.11}/" ` + proxyConfigTemplate + ` > /etc/nginx/nginx.conf
+exec nginx -g 'daemon off;'`
+
+// proxyConfig renders the nginx stream config of a Service proxy, one upstream per Service port.
+// Backends are resolved through the engine's DNS at runtime, so the proxy starts before them and follows restarts
+func proxyConfig(serviceInfo spec.ServiceInfo, backends []*spec.App) string {
+	var config strings.Builder
+	config.WriteString("worker_processes 1;\nevents {}\n\nstream {\n    resolver @RESOLVER@ valid=10s ipv6=off;\n")
+	for j, portInfo := range serviceInfo.Ports {
+		protocol := strings.ToLower(portInfo.Protocol)
+		if protocol == "sctp" {
+			fmt.Printf("warning: service %s: nginx cannot proxy SCTP port %d, skipping it\n", serviceInfo.Name, portInfo.Port)
+			continue
+		}
+		upstream := fmt.Sprintf("%s-%d-%s", serviceInfo.Name, portInfo.Port, protocol)
+		var servers []string
+		for _, backend := range backends {
+			if containerPort := backend.Endpoints[serviceInfo.Name][j]; containerPort != 0 {
+				servers = append(servers, fmt.Sprintf("        server %s:%d resolve;\n", endpointAlias(serviceInfo, backend), containerPort))
+			}
+		}
+		if len(servers) == 0 {
+			continue
+		}
+		listen := fmt.Sprintf("%d", portInfo.Port)
+		if protocol == "udp" {
+			listen += " udp"
+		}
+		fmt.Fprintf(&config, "\n    upstream %s {\n        zone %s 64k;\n%s    }\n", upstream, upstream, strings.Join(servers, ""))
+		fmt.Fprintf(&config, "    server {\n        listen %s;\n        proxy_pass %s;\n    }\n", listen, upstream)
+	}
+	config.WriteString("}\n")
+	return config.String()
+}
+
+// endpointAlias is the name a Service proxy reaches one of its backends by, shared by all its replicas
+func endpointAlias(serviceInfo spec.ServiceInfo, backend *spec.App) string {
+	return fmt.Sprintf("%s.%s.endpoints", backend.Name, serviceInfo.Name)
+}
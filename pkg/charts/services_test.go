@@ -0,0 +1,32 @@
+package charts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+)
+
+func TestProxyConfig(t *testing.T) {
+	serviceInfo := spec.ServiceInfo{Name: "web", Ports: []spec.PortInfo{
+		{Port: 80, TargetPort: 8080, Protocol: "TCP"},
+		{Port: 53, TargetPort: 5353, Protocol: "UDP"},
+	}}
+	backend := &spec.App{Name: "rel-web-web", Endpoints: map[string][]int{"web": {8080, 5353}}}
+	config := proxyConfig(serviceInfo, []*spec.App{backend})
+	for _, want := range []string{
+		// the resolver is the container's nameserver, filled in by proxyStartScript
+		"resolver @RESOLVER@ valid=10s ipv6=off;",
+		"server rel-web-web.web.endpoints:8080 resolve;",
+		"listen 80;",
+		"server rel-web-web.web.endpoints:5353 resolve;",
+		"listen 53 udp;",
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("proxy config is missing %q:\n%s", want, config)
+		}
+	}
+	if !strings.Contains(proxyStartScript, proxyConfigTemplate) {
+		t.Errorf("proxyStartScript doesn't render %s", proxyConfigTemplate)
+	}
+}
@@ -42,7 +42,6 @@ func (utils *ChartUtils) Template(chart string, valuesPath string, setValues []s
 	renderer.DryRun = true
 	renderer.ReleaseName = ExtractName(chart)
 	renderer.Namespace = "default"
-	renderer.DisableHooks = true
 
 	values, err := unmarshalWithOverride(valuesPath)
 	//TODO: use setValues to add/override stuff
@@ -0,0 +1,295 @@
+package charts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// labelPrefix namespaces the labels compose attaches to generated resources
+const labelPrefix = "io.github.ashupednekar.compose."
+
+// claimTemplateVolumes resolves the container's volumeMounts that refer to a volumeClaimTemplate.
+// Claims are named <template>-<pod>, i.e. <template>-<statefulset>-<ordinal>, the same way the StatefulSet controller does,
+// and their volumes are prefixed with the release
+func claimTemplateVolumes(container corev1.Container, claimTemplates []corev1.PersistentVolumeClaim, podName string, release string) []spec.AppVolume {
+	var volumes []spec.AppVolume
+	for _, mount := range container.VolumeMounts {
+		for _, claimTemplate := range claimTemplates {
+			if claimTemplate.Name != mount.Name {
+				continue
+			}
+			claimName := fmt.Sprintf("%s-%s", mount.Name, podName)
+			volumes = append(volumes, claimVolume(volumeName(release, claimName), claimName, &claimTemplate.Spec, mount))
+		}
+	}
+	return volumes
+}
+
+// claimVolume builds a named volume for a PersistentVolumeClaim, carrying the claim details as labels.
+// claimSpec is nil when the claim is not part of the release
+func claimVolume(volumeName string, claimName string, claimSpec *corev1.PersistentVolumeClaimSpec, mount corev1.VolumeMount) spec.AppVolume {
+	labels := map[string]string{
+		labelPrefix + "claim-name": claimName,
+	}
+	if claimSpec != nil {
+		if storage, exists := claimSpec.Resources.Requests[corev1.ResourceStorage]; exists {
+			labels[labelPrefix+"size"] = storage.String()
+		}
+		if claimSpec.StorageClassName != nil && *claimSpec.StorageClassName != "" {
+			labels[labelPrefix+"storage-class"] = *claimSpec.StorageClassName
+		}
+	}
+	return spec.AppVolume{
+		Name:      volumeName,
+		MountPath: mount.MountPath,
+		SubPath:   mount.SubPath,
+		ReadOnly:  mount.ReadOnly,
+		Labels:    labels,
+	}
+}
+
+// emptyDirVolume builds the per-pod named volume backing an emptyDir, <release>-<pod>-<volume>.
+// Memory backed emptyDirs become tmpfs volumes so every container of the pod still shares them,
+// and the empty-dir label lets restart.sh drop them when the pod is recreated
+func emptyDirVolume(release string, podName string, podVolume string, emptyDir *corev1.EmptyDirVolumeSource, mount corev1.VolumeMount) spec.AppVolume {
+	volume := spec.AppVolume{
+		Name:      volumeName(release, fmt.Sprintf("%s-%s", podName, podVolume)),
+		MountPath: mount.MountPath,
+		SubPath:   mount.SubPath,
+		ReadOnly:  mount.ReadOnly,
+		Labels: map[string]string{
+			labelPrefix + "empty-dir": podName,
+		},
+	}
+	if emptyDir.Medium != corev1.StorageMediumMemory {
+		return volume
+	}
+	options := "mode=1777"
+	if emptyDir.SizeLimit != nil {
+		options = fmt.Sprintf("%s,size=%d", options, emptyDir.SizeLimit.Value())
+	}
+	volume.Driver = "local"
+	volume.DriverOpts = map[string]string{
+		"type":   "tmpfs",
+		"device": "tmpfs",
+		"o":      options,
+	}
+	return volume
+}
+
+// supportedVolumeSources lists the pod volume sources extractPodApps knows how to translate
+var supportedVolumeSources = map[string]bool{
+	"configMap":             true,
+	"secret":                true,
+	"emptyDir":              true,
+	"persistentVolumeClaim": true,
+	"hostPath":              true,
+	"projected":             true,
+	"downwardAPI":           true,
+}
+
+// volumeSourceType returns the kind of source backing a pod volume or projection, e.g. configMap or nfs
+func volumeSourceType(source interface{}) string {
+	// the source is the one field set, named after its JSON key
+	data, err := json.Marshal(source)
+	if err != nil {
+		return ""
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ""
+	}
+	for field := range fields {
+		return field
+	}
+	return ""
+}
+
+// hostPathBind builds the bind mount for a hostPath volume
+func hostPathBind(hostPath *corev1.HostPathVolumeSource, mount corev1.VolumeMount) spec.AppBind {
+	path := hostPath.Path
+	if mount.SubPath != "" {
+		path = filepath.Join(path, mount.SubPath)
+	}
+	bind := spec.AppBind{
+		HostPath:  path,
+		MountPath: mount.MountPath,
+		ReadOnly:  mount.ReadOnly,
+	}
+	if hostPath.Type != nil {
+		bind.Type = string(*hostPath.Type)
+	}
+	return bind
+}
+
+// prepareHostPath applies the hostPath type checks on this host, creating the path for the *OrCreate types
+func prepareHostPath(bind spec.AppBind) error {
+	switch bind.Type {
+	case "", "Unset":
+		return nil
+	case "DirectoryOrCreate":
+		if err := os.MkdirAll(bind.HostPath, 0755); err != nil {
+			return fmt.Errorf("error creating hostPath directory %s: %v", bind.HostPath, err)
+		}
+		return nil
+	case "FileOrCreate":
+		if _, err := os.Stat(bind.HostPath); os.IsNotExist(err) {
+			if err := os.MkdirAll(filepath.Dir(bind.HostPath), 0755); err != nil {
+				return fmt.Errorf("error creating hostPath directory %s: %v", filepath.Dir(bind.HostPath), err)
+			}
+			file, err := os.OpenFile(bind.HostPath, os.O_CREATE|os.O_RDONLY, 0644)
+			if err != nil {
+				return fmt.Errorf("error creating hostPath file %s: %v", bind.HostPath, err)
+			}
+			return file.Close()
+		}
+		return nil
+	}
+
+	info, err := os.Stat(bind.HostPath)
+	if err != nil {
+		return fmt.Errorf("hostPath %s of type %s does not exist", bind.HostPath, bind.Type)
+	}
+	mode := info.Mode()
+	var matches bool
+	switch bind.Type {
+	case "Directory":
+		matches = mode.IsDir()
+	case "File":
+		matches = mode.IsRegular()
+	case "Socket":
+		matches = mode&os.ModeSocket != 0
+	case "CharDevice":
+		matches = mode&os.ModeDevice != 0 && mode&os.ModeCharDevice != 0
+	case "BlockDevice":
+		matches = mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0
+	default:
+		return fmt.Errorf("unknown hostPath type %s for %s", bind.Type, bind.HostPath)
+	}
+	if !matches {
+		return fmt.Errorf("hostPath %s is not of type %s", bind.HostPath, bind.Type)
+	}
+	return nil
+}
+
+// mountVolumeFiles adds the files materialized by a volume to the app mounts, honoring subPath
+func mountVolumeFiles(app *spec.App, files map[string]string, mount corev1.VolumeMount) {
+	if mount.SubPath != "" {
+		if content, exists := files[mount.SubPath]; exists {
+			app.Mounts[mount.MountPath] = content
+		}
+		return
+	}
+	for path, content := range files {
+		app.Mounts[mount.MountPath+"/"+path] = content
+	}
+}
+
+// keyToPathFiles selects the keys of a ConfigMap or Secret, remapped through items if given
+func keyToPathFiles(data map[string]string, items []corev1.KeyToPath) map[string]string {
+	files := make(map[string]string)
+	if len(items) == 0 {
+		for key, value := range data {
+			files[key] = value
+		}
+		return files
+	}
+	for _, item := range items {
+		if value, exists := data[item.Key]; exists {
+			files[item.Path] = value
+		}
+	}
+	return files
+}
+
+// configMapFiles returns the files of a configMap volume source or projection, keyed by their path in the volume
+func configMapFiles(name string, items []corev1.KeyToPath, optional *bool, configMaps map[string]*corev1.ConfigMap) map[string]string {
+	configMap, exists := configMaps[name]
+	if !exists {
+		if optional == nil || !*optional {
+			fmt.Printf("warning: configmap %s referenced by volume not found\n", name)
+		}
+		return map[string]string{}
+	}
+	return keyToPathFiles(configMapData(configMap), items)
+}
+
+// secretFiles returns the files of a secret volume source or projection, keyed by their path in the volume
+func secretFiles(name string, items []corev1.KeyToPath, optional *bool, secrets map[string]*corev1.Secret) map[string]string {
+	secret, exists := secrets[name]
+	if !exists {
+		if optional == nil || !*optional {
+			fmt.Printf("warning: secret %s referenced by volume not found\n", name)
+		}
+		return map[string]string{}
+	}
+	return keyToPathFiles(secretData(secret), items)
+}
+
+// downwardAPIFiles returns the files of a downwardAPI volume source or projection, keyed by their path in the volume
+func downwardAPIFiles(items []corev1.DownwardAPIVolumeFile, pod podIdentity) map[string]string {
+	files := make(map[string]string)
+	for _, item := range items {
+		if item.FieldRef != nil {
+			value, ok := pod.fieldRef(item.FieldRef.FieldPath)
+			if !ok {
+				fmt.Printf("warning: downwardAPI field %s for %s is not supported, skipping\n", item.FieldRef.FieldPath, item.Path)
+				continue
+			}
+			files[item.Path] = value
+		} else if item.ResourceFieldRef != nil {
+			// volumes have no container of their own, containerName is required
+			value, err := pod.resourceFieldRef(item.ResourceFieldRef, "")
+			if err != nil {
+				fmt.Printf("warning: downwardAPI resourceFieldRef for %s: %v, skipping\n", item.Path, err)
+				continue
+			}
+			files[item.Path] = value
+		}
+	}
+	return files
+}
+
+// projectedFiles merges the files of every source of a projected volume
+func projectedFiles(projected *corev1.ProjectedVolumeSource, pod podIdentity, resources *releaseResources) map[string]string {
+	files := make(map[string]string)
+	for _, projection := range projected.Sources {
+		var projectedFiles map[string]string
+		if projection.ConfigMap != nil {
+			projectedFiles = configMapFiles(projection.ConfigMap.Name, projection.ConfigMap.Items, projection.ConfigMap.Optional, resources.configMaps)
+		} else if projection.Secret != nil {
+			projectedFiles = secretFiles(projection.Secret.Name, projection.Secret.Items, projection.Secret.Optional, resources.secrets)
+		} else if projection.DownwardAPI != nil {
+			projectedFiles = downwardAPIFiles(projection.DownwardAPI.Items, pod)
+		} else {
+			fmt.Printf("warning: projected volume source %s is not supported, skipping\n", volumeSourceType(projection))
+			continue
+		}
+		for path, content := range projectedFiles {
+			files[path] = content
+		}
+	}
+	return files
+}
+
+// formatDownwardAPIMap renders labels or annotations the way the kubelet writes them, one key="value" per line
+func formatDownwardAPIMap(values map[string]string) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s=%s", key, strconv.Quote(values[key])))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,105 @@
+package charts
+
+import (
+	"fmt"
+
+	"github.com/ashupednekar/compose/pkg/spec"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// workload is a resource running pods, normalized around its pod template so Deployments, StatefulSets,
+// DaemonSets, Jobs, CronJobs and bare Pods all translate the same way
+type workload struct {
+	kind      string
+	name      string
+	namespace string
+	template  corev1.PodTemplateSpec
+	replicas  int
+
+	// StatefulSets only
+	serviceName         string
+	podManagementPolicy appsv1.PodManagementPolicyType
+	claimTemplates      []corev1.PersistentVolumeClaim
+
+	// Jobs and CronJobs only
+	job *spec.JobSpec
+}
+
+// workloadKinds are the kinds of the workloads compose runs, reported when of an unsupported API version
+var workloadKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+	"Job":         true,
+	"CronJob":     true,
+	"Pod":         true,
+}
+
+// normalizeWorkload returns the workload of a decoded object, nil if the object doesn't run pods
+func normalizeWorkload(object runtime.Object) (*workload, error) {
+	switch resource := object.(type) {
+	case *appsv1.Deployment:
+		w := newWorkload("Deployment", resource, resource.Spec.Template)
+		w.replicas = replicaCount(resource.Spec.Replicas)
+		return w, nil
+	case *appsv1.StatefulSet:
+		w := newWorkload("StatefulSet", resource, resource.Spec.Template)
+		w.replicas = replicaCount(resource.Spec.Replicas)
+		w.serviceName = resource.Spec.ServiceName
+		w.podManagementPolicy = resource.Spec.PodManagementPolicy
+		w.claimTemplates = resource.Spec.VolumeClaimTemplates
+		return w, nil
+	case *appsv1.DaemonSet:
+		return newWorkload("DaemonSet", resource, resource.Spec.Template), nil
+	case *batchv1.Job:
+		w := newWorkload("Job", resource, resource.Spec.Template)
+		w.job = jobSpecFor(resource.Spec)
+		return w, nil
+	case *batchv1.CronJob:
+		w := newWorkload("CronJob", resource, resource.Spec.JobTemplate.Spec.Template)
+		job, err := cronJobSpecFor(resource)
+		if err != nil {
+			return nil, fmt.Errorf("error extracting job: %v", err)
+		}
+		w.job = job
+		return w, nil
+	case *corev1.Pod:
+		// a bare Pod is its own template
+		return newWorkload("Pod", resource, corev1.PodTemplateSpec{
+			ObjectMeta: resource.ObjectMeta,
+			Spec:       resource.Spec,
+		}), nil
+	}
+	return nil, nil
+}
+
+// newWorkload normalizes the metadata and pod template shared by every workload kind
+func newWorkload(kind string, object metav1.Object, template corev1.PodTemplateSpec) *workload {
+	return &workload{
+		kind:      kind,
+		name:      object.GetName(),
+		namespace: resourceNamespace(object),
+		template:  template,
+		replicas:  1,
+	}
+}
+
+// replicaCount returns spec.replicas, 1 when unset like the API server defaults it
+func replicaCount(replicas *int32) int {
+	if replicas == nil {
+		return 1
+	}
+	return int(*replicas)
+}
+
+// resourceNamespace returns the namespace of a resource, the release namespace if unset
+func resourceNamespace(object metav1.Object) string {
+	if namespace := object.GetNamespace(); namespace != "" {
+		return namespace
+	}
+	return "default"
+}
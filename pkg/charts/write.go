@@ -2,24 +2,145 @@ package charts
 
 import (
 	"fmt"
-	"os"
-	"strings"
 	"github.com/ashupednekar/compose/pkg"
 	"github.com/ashupednekar/compose/pkg/spec"
 	"go.yaml.in/yaml/v3"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
-func WriteCompose(apps []spec.App, name string) error {
-	useRootDir := len(apps) == 1
-	var composeDirs []string
-	
-	for _, app := range apps{
-		dockerCompose := spec.DockerCompose{
-			Services: make(map[string]spec.DockerComposeService),
-			Networks: map[string]interface{}{
-				name: map[string]interface{}{"name": name},
-			},
+// Output layouts of WriteCompose
+const (
+	// LayoutRelease writes the whole release as a single compose project
+	LayoutRelease = "release"
+	// LayoutApp writes a compose project per app, next to the init containers it waits on
+	LayoutApp = "app"
+)
+
+// WriteCompose writes the apps of a release in the given layout. restart.sh and run-job.sh drive the engine's
+// compose and container commands, and podInfra groups the containers of each pod around an infra container.
+// With Podman and the release layout, pods are Podman pods instead
+func WriteCompose(apps []spec.App, name string, layout string, engine string, podInfra bool) error {
+	if engine != EngineDocker && engine != EnginePodman {
+		return fmt.Errorf("unknown engine %s, expected %s or %s", engine, EngineDocker, EnginePodman)
+	}
+	switch layout {
+	case LayoutRelease:
+		apps = joinNetworkOwners(apps)
+		if podInfra && engine == EnginePodman {
+			fmt.Printf("warning: pods are Podman pods with the %s engine, their infra containers are Podman's own\n", EnginePodman)
+		} else if podInfra {
+			apps = podInfraContainers(apps)
+		}
+		return writeReleaseCompose(apps, name, engine)
+	case LayoutApp:
+		if podInfra || engine == EnginePodman {
+			fmt.Printf("warning: pods are only grouped with the %s layout, sidecars keep their own namespaces\n", LayoutRelease)
+		}
+		return writeAppCompose(apps, name, engine)
+	}
+	return fmt.Errorf("unknown layout %s, expected %s or %s", layout, LayoutRelease, LayoutApp)
+}
+
+// writeReleaseCompose writes every app of the release into one compose project, so sidecars, depends_on
+// and Service proxies refer to services of the same file. Mounted files go to a directory per app,
+// and Jobs get theirs under jobs/ for run-job.sh. With Podman, multi-container pods are written
+// under pods/ for podman kube play instead
+func writeReleaseCompose(apps []spec.App, name string, engine string) error {
+	releaseDir := fmt.Sprintf("%s/%s", pkg.Settings.ManifestDir, name)
+	if err := os.MkdirAll(releaseDir, 0755); err != nil {
+		return fmt.Errorf("error creating manifest subdirectory")
+	}
+	var podApps [][]spec.App
+	if engine == EnginePodman {
+		apps, podApps = podmanPods(apps)
+	}
+	// pods played by earlier syncs are replaced as a whole
+	if err := os.RemoveAll(fmt.Sprintf("%s/pods", releaseDir)); err != nil {
+		return fmt.Errorf("error clearing pods directory: %v", err)
+	}
+	appsByName := make(map[string]spec.App)
+	grouped := make(map[string]bool)
+	for _, app := range apps {
+		appsByName[app.Name] = app
+		grouped[app.Name] = true
+	}
+	dockerCompose := newDockerCompose(name)
+	for _, app := range apps {
+		if err := addComposeService(&dockerCompose, app, releaseDir, app.Name, name, appsByName, grouped); err != nil {
+			return err
+		}
+	}
+	composeFile := fmt.Sprintf("%s/docker-compose.yaml", releaseDir)
+	if err := writeDockerCompose(&dockerCompose, composeFile); err != nil {
+		return err
+	}
+	fmt.Printf("docker-compose.yaml written to %s\n", releaseDir)
+
+	plan := restartPlan{engine: engine, composeDirs: []string{releaseDir}, hookDirs: make(map[string][]string)}
+	for _, members := range podApps {
+		pod, err := writePodmanPod(members, releaseDir, name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("pod %s written to %s\n", pod.name, pod.file)
+		plan.pods = append(plan.pods, pod)
+	}
+	plan.legacyDirs = legacyProjects(releaseDir, []string{releaseDir})
+	var hookApps []spec.App
+	hookAppDirs := make(map[string]string)
+	for _, app := range apps {
+		if app.Job == nil || app.Init {
+			continue
+		}
+		jobDir := fmt.Sprintf("%s/jobs/%s", releaseDir, app.Name)
+		if err := os.MkdirAll(jobDir, 0755); err != nil {
+			return fmt.Errorf("error creating job directory")
 		}
+		// run-job.sh reruns a Job when its own service changed, not on any change to the release
+		data, err := yaml.Marshal(dockerCompose.Services[app.Name])
+		if err != nil {
+			return fmt.Errorf("error marshaling job %s: %v", app.Name, err)
+		}
+		if err := os.WriteFile(fmt.Sprintf("%s/job.yaml", jobDir), data, 0644); err != nil {
+			return fmt.Errorf("error writing job spec: %v", err)
+		}
+		fragment, err := writeJobScripts(app, jobDir, composeFile, "job.yaml", name, engine)
+		if err != nil {
+			return err
+		}
+		plan.addJob(app, jobDir, fragment, &hookApps, hookAppDirs)
+	}
+	plan.addHooks(hookApps, hookAppDirs)
+
+	if err := generateRestartScript(plan, name, false); err != nil {
+		return fmt.Errorf("error generating restart script: %v", err)
+	}
+	return nil
+}
+
+// writeAppCompose writes a compose project per app. depends_on and network_mode cannot refer to services
+// of another project, so only those on the app's own init containers are kept
+func writeAppCompose(apps []spec.App, name string, engine string) error {
+	appsByName := make(map[string]spec.App)
+	var mainApps []spec.App
+	for _, app := range apps {
+		appsByName[app.Name] = app
+		if !app.Init {
+			mainApps = append(mainApps, app)
+		}
+	}
+	useRootDir := len(mainApps) == 1
+	plan := restartPlan{engine: engine, hookDirs: make(map[string][]string)}
+	var hookApps []spec.App
+	hookAppDirs := make(map[string]string)
+	var writtenDirs []string
+	
+	for _, app := range mainApps {
+		dockerCompose := newDockerCompose(name)
 		
 		var composeDir string
 		if useRootDir && name == app.Name{
@@ -27,93 +148,507 @@ func WriteCompose(apps []spec.App, name string) error {
 		}else{
 			composeDir = fmt.Sprintf("%s/%s/%s", pkg.Settings.ManifestDir, name, app.Name)
 		}
-		composeDirs = append(composeDirs, composeDir)
+		if app.Job == nil {
+			plan.composeDirs = append(plan.composeDirs, composeDir)
+		}
+		writtenDirs = append(writtenDirs, composeDir)
 		
 		if err := os.MkdirAll(composeDir, 0755); err != nil{
 			return fmt.Errorf("error creating manifest subdirectory")
 		}
-		
-		service := spec.DockerComposeService{
-			Image: app.Image,
-			Command: app.Command,
-			Restart: "unless-stopped",
-			Volumes: []string{},
-			Ports: app.Ports,
-			Environment: app.Configs,
-			Networks: []string{name},
-		}
-		for mount, content := range app.Mounts{
-			parts := strings.Split(mount, "/") 
-			mountFileName := parts[len(parts)-1]
-			//TODO: :Z/:z for podman permissions
-			if err := os.WriteFile(
-				fmt.Sprintf("%s/%s", composeDir, mountFileName), []byte(content), 0644,
-			); err != nil{
-				return fmt.Errorf("error writing mapped file: %v\n", err)
+
+		// init containers are written next to the app waiting on them,
+		// since depends_on cannot refer to services of another compose project
+		group := append([]spec.App{app}, initDependencies(app, appsByName)...)
+		grouped := make(map[string]bool)
+		for _, member := range group {
+			grouped[member.Name] = true
+		}
+		for _, member := range group {
+			if err := addComposeService(&dockerCompose, member, composeDir, "", name, appsByName, grouped); err != nil {
+				return err
 			}
-			volumeMount := fmt.Sprintf("./%s:%s", mountFileName, mount) 
-			service.Volumes = append(service.Volumes, volumeMount)
 		}
 		
-		dockerCompose.Services[app.Name] = service
-		data, err := yaml.Marshal(&dockerCompose)
-		if err != nil{
-			return fmt.Errorf("error marshaling docker-compose to yaml: %v\n", err)
-		}
-		if err := os.WriteFile(
-			fmt.Sprintf("%s/docker-compose.yaml", composeDir), data, 0644,
-		); err != nil{
-			return fmt.Errorf("error writing docker-compose yaml %v\n", err)
+		composeFile := fmt.Sprintf("%s/docker-compose.yaml", composeDir)
+		if err := writeDockerCompose(&dockerCompose, composeFile); err != nil {
+			return err
 		}
 		fmt.Printf("docker-compose.yaml written to %s\n", composeDir)
+
+		if app.Job != nil {
+			fragment, err := writeJobScripts(app, composeDir, composeFile, "docker-compose.yaml", name, engine)
+			if err != nil {
+				return err
+			}
+			plan.addJob(app, composeDir, fragment, &hookApps, hookAppDirs)
+		}
 	}
+	plan.addHooks(hookApps, hookAppDirs)
+	plan.legacyDirs = legacyProjects(fmt.Sprintf("%s/%s", pkg.Settings.ManifestDir, name), writtenDirs)
 	
-	if err := generateRestartScript(composeDirs, name, useRootDir); err != nil {
+	if err := generateRestartScript(plan, name, useRootDir); err != nil {
 		return fmt.Errorf("error generating restart script: %v", err)
 	}
 	
 	return nil
 }
 
-func generateRestartScript(composeDirs []string, name string, useRootDir bool) error {
+// legacyProjects returns the compose projects under releaseDir that earlier syncs wrote and this one did not:
+// those of the other layout, and per-app projects named after their containers only. They would keep running,
+// holding on to the ports and names of the release
+func legacyProjects(releaseDir string, writtenDirs []string) []string {
+	written := make(map[string]bool)
+	for _, dir := range writtenDirs {
+		written[filepath.Clean(dir)] = true
+	}
+	composeFiles, _ := filepath.Glob(fmt.Sprintf("%s/*/docker-compose.yaml", releaseDir))
+	composeFiles = append([]string{fmt.Sprintf("%s/docker-compose.yaml", releaseDir)}, composeFiles...)
+	var legacyDirs []string
+	for _, composeFile := range composeFiles {
+		dir := filepath.Dir(composeFile)
+		if _, err := os.Stat(composeFile); err != nil || written[dir] {
+			continue
+		}
+		legacyDirs = append(legacyDirs, dir)
+	}
+	if len(legacyDirs) > 0 {
+		fmt.Printf("warning: found %d compose projects of earlier syncs in %s, restart.sh tears them down once\n", len(legacyDirs), releaseDir)
+	}
+	return legacyDirs
+}
+
+// newDockerCompose returns an empty compose project on the release network
+func newDockerCompose(name string) spec.DockerCompose {
+	return spec.DockerCompose{
+		Services: make(map[string]spec.DockerComposeService),
+		Networks: map[string]interface{}{
+			name: map[string]interface{}{"name": name},
+		},
+	}
+}
+
+// addComposeService renders an app into dockerCompose. Its depends_on and network_mode are kept
+// when they refer to a grouped service, one of the same project
+func addComposeService(dockerCompose *spec.DockerCompose, member spec.App, composeDir string, fileDir string, name string, appsByName map[string]spec.App, grouped map[string]bool) error {
+	service, err := composeService(member, composeDir, fileDir, name, dockerCompose)
+	if err != nil {
+		return err
+	}
+	for dependency, condition := range member.DependsOn {
+		if !grouped[dependency] {
+			continue
+		}
+		if service.DependsOn == nil {
+			service.DependsOn = make(map[string]spec.DockerComposeDependency)
+		}
+		// a ready pod is one whose readinessProbe passes
+		if target := appsByName[dependency]; condition == "service_started" && target.Healthcheck != nil && target.Healthcheck.Readiness {
+			condition = "service_healthy"
+		}
+		service.DependsOn[dependency] = spec.DockerComposeDependency{Condition: condition}
+	}
+	// same for sharing the network namespace of another service
+	if target, isService := strings.CutPrefix(member.NetworkMode, "service:"); !isService || grouped[target] {
+		service.NetworkMode = member.NetworkMode
+	}
+	if service.NetworkMode != "" {
+		// network_mode cannot be combined with networks, nor with extra_hosts
+		service.Networks = nil
+		service.ExtraHosts = nil
+	}
+	dockerCompose.Services[member.Name] = service
+	return nil
+}
+
+// writeDockerCompose writes a compose project to composeFile
+func writeDockerCompose(dockerCompose *spec.DockerCompose, composeFile string) error {
+	data, err := yaml.Marshal(dockerCompose)
+	if err != nil {
+		return fmt.Errorf("error marshaling docker-compose to yaml: %v\n", err)
+	}
+	if err := os.WriteFile(composeFile, data, 0644); err != nil {
+		return fmt.Errorf("error writing docker-compose yaml %v\n", err)
+	}
+	return nil
+}
+
+// initDependencies returns the init container apps an app transitively waits on
+func initDependencies(app spec.App, appsByName map[string]spec.App) []spec.App {
+	var dependencies []spec.App
+	seen := map[string]bool{app.Name: true}
+	pending := []spec.App{app}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		for dependency := range current.DependsOn {
+			dependencyApp, exists := appsByName[dependency]
+			if !exists || !dependencyApp.Init || seen[dependency] {
+				continue
+			}
+			seen[dependency] = true
+			dependencies = append(dependencies, dependencyApp)
+			pending = append(pending, dependencyApp)
+		}
+	}
+	return dependencies
+}
+
+// composeService renders an app as a compose service, writing its mounted files into fileDir under composeDir
+// and declaring its named volumes on dockerCompose
+func composeService(app spec.App, composeDir string, fileDir string, name string, dockerCompose *spec.DockerCompose) (spec.DockerComposeService, error) {
+	if app.Compose != nil {
+		return fragmentService(app, name, dockerCompose), nil
+	}
+	restart := app.Restart
+	if restart == "" {
+		restart = "unless-stopped"
+	}
+	service := spec.DockerComposeService{
+		Image:       app.Image,
+		Command:     escapeInterpolationAll(app.Command),
+		Restart:     restart,
+		Volumes:     []spec.DockerComposeServiceVolume{},
+		Ports:       app.Ports,
+		Environment: make(map[string]string),
+		Hostname:    app.Hostname,
+		Domainname:  app.Domainname,
+		Networks: map[string]spec.DockerComposeServiceNetwork{
+			name: {Aliases: app.Aliases},
+		},
+		ExtraHosts: app.ExtraHosts,
+		Pid:        app.Pid,
+		Ipc:        app.Ipc,
+		Labels:     app.Labels,
+	}
+	// values are passed through as is, compose must not interpolate them
+	for key, value := range app.Configs {
+		service.Environment[key] = escapeInterpolation(value)
+	}
+	if app.Replicas != nil || app.Resources != nil {
+		service.Deploy = &spec.DockerComposeDeploy{Replicas: app.Replicas}
+	}
+	if app.Resources != nil {
+		// the short forms match deploy.resources, for engines that ignore the deploy section
+		service.Deploy.Resources = composeResources(app.Resources)
+		service.MemLimit = app.Resources.MemoryLimit
+		service.MemReservation = app.Resources.MemoryRequest
+		service.Cpus = formatCpus(app.Resources.CPULimit)
+	}
+	if app.Job != nil {
+		service.Profiles = []string{jobProfile}
+	}
+	if app.Security != nil {
+		service.User = app.Security.User
+		service.GroupAdd = app.Security.GroupAdd
+		service.ReadOnly = app.Security.ReadOnly
+		service.Privileged = app.Security.Privileged
+		service.CapAdd = app.Security.CapAdd
+		service.CapDrop = app.Security.CapDrop
+		if app.Security.NoNewPrivileges {
+			service.SecurityOpt = []string{"no-new-privileges:true"}
+		}
+	}
+	if app.Healthcheck != nil {
+		service.Healthcheck = &spec.DockerComposeHealthcheck{
+			Test:     escapeInterpolationAll(app.Healthcheck.Test),
+			Interval: fmt.Sprintf("%ds", app.Healthcheck.Interval),
+			Timeout:  fmt.Sprintf("%ds", app.Healthcheck.Timeout),
+			Retries:  app.Healthcheck.Retries,
+		}
+		if app.Healthcheck.StartPeriod > 0 {
+			service.Healthcheck.StartPeriod = fmt.Sprintf("%ds", app.Healthcheck.StartPeriod)
+		}
+	}
+	mounts := make([]string, 0, len(app.Mounts))
+	for mount := range app.Mounts {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+	for _, mount := range mounts {
+		content := app.Mounts[mount]
+		// files mirror their mount path, volumes mounted side by side often share key names like ca.crt
+		mountFileName := strings.TrimPrefix(path.Clean("/"+mount), "/")
+		if fileDir != "" {
+			mountFileName = fmt.Sprintf("%s/%s", fileDir, mountFileName)
+		}
+		if err := os.MkdirAll(filepath.Dir(fmt.Sprintf("%s/%s", composeDir, mountFileName)), 0755); err != nil {
+			return service, fmt.Errorf("error creating mapped file directory: %v\n", err)
+		}
+		//TODO: :Z/:z for podman permissions
+		if err := os.WriteFile(
+			fmt.Sprintf("%s/%s", composeDir, mountFileName), []byte(content), 0644,
+		); err != nil {
+			return service, fmt.Errorf("error writing mapped file: %v\n", err)
+		}
+		service.Volumes = append(service.Volumes, spec.DockerComposeServiceVolume{
+			Type:   "bind",
+			Source: fmt.Sprintf("./%s", mountFileName),
+			Target: path.Clean(mount),
+		})
+	}
+	for _, bind := range app.Binds {
+		if err := prepareHostPath(bind); err != nil {
+			fmt.Printf("warning: %s: %v\n", app.Name, err)
+		}
+		serviceVolume := spec.DockerComposeServiceVolume{
+			Type:     "bind",
+			Source:   bind.HostPath,
+			Target:   bind.MountPath,
+			ReadOnly: bind.ReadOnly,
+		}
+		if bind.Type == "DirectoryOrCreate" {
+			serviceVolume.Bind = &spec.DockerComposeBindOptions{CreateHostPath: true}
+		}
+		service.Volumes = append(service.Volumes, serviceVolume)
+	}
+	for _, volume := range app.Volumes {
+		// explicit names keep the data attached across re-syncs and restarts
+		serviceVolume := spec.DockerComposeServiceVolume{
+			Type:     "volume",
+			Source:   volume.Name,
+			Target:   volume.MountPath,
+			ReadOnly: volume.ReadOnly,
+		}
+		if volume.SubPath != "" {
+			serviceVolume.Volume = &spec.DockerComposeVolumeOptions{Subpath: volume.SubPath}
+		}
+		service.Volumes = append(service.Volumes, serviceVolume)
+		if dockerCompose.Volumes == nil {
+			dockerCompose.Volumes = make(map[string]spec.DockerComposeVolume)
+		}
+		labels := map[string]string{labelPrefix + "release": name}
+		for k, v := range volume.Labels {
+			labels[k] = v
+		}
+		dockerCompose.Volumes[volume.Name] = spec.DockerComposeVolume{
+			Name:       volume.Name,
+			Driver:     volume.Driver,
+			DriverOpts: volume.DriverOpts,
+			Labels:     labels,
+		}
+	}
+	return service, nil
+}
+
+// fragmentService returns the service an external converter rendered, joined to the release network
+func fragmentService(app spec.App, name string, dockerCompose *spec.DockerCompose) spec.DockerComposeService {
+	service := make(map[string]interface{}, len(app.Compose)+1)
+	for key, value := range app.Compose {
+		service[key] = value
+	}
+	_, hasNetworks := service["networks"]
+	_, hasNetworkMode := service["network_mode"]
+	if !hasNetworks && !hasNetworkMode {
+		service["networks"] = map[string]spec.DockerComposeServiceNetwork{
+			name: {Aliases: app.Aliases},
+		}
+	}
+	for volumeName, volume := range app.ComposeVolumes {
+		if dockerCompose.Volumes == nil {
+			dockerCompose.Volumes = make(map[string]spec.DockerComposeVolume)
+		}
+		dockerCompose.Volumes[volumeName] = volume
+	}
+	return spec.DockerComposeService{Fragment: service}
+}
+
+// restartPlan lists what restart.sh manages for a release
+type restartPlan struct {
+	engine        string
+	composeDirs   []string
+	jobDirs       []string
+	cronFragments []string
+	hookDirs      map[string][]string // hook event -> job dirs, in execution order
+	legacyDirs    []string            // projects of earlier syncs this one no longer writes, torn down once
+	pods          []podmanPod         // pods played with podman kube play, after the compose project
+}
+
+// addJob plans the run of a Job, CronJob or hook whose run-job.sh is in jobDir
+func (plan *restartPlan) addJob(app spec.App, jobDir string, cronFragment string, hookApps *[]spec.App, hookAppDirs map[string]string) {
+	if app.Hook != nil {
+		*hookApps = append(*hookApps, app)
+		hookAppDirs[app.Name] = jobDir
+	} else if cronFragment != "" {
+		plan.cronFragments = append(plan.cronFragments, cronFragment)
+	} else {
+		plan.jobDirs = append(plan.jobDirs, jobDir)
+	}
+}
+
+// addHooks plans the hooks of each event, in weight order
+func (plan *restartPlan) addHooks(hookApps []spec.App, hookAppDirs map[string]string) {
+	sortHooks(hookApps)
+	for _, hook := range hookApps {
+		for _, event := range hook.Hook.Events {
+			plan.hookDirs[event] = append(plan.hookDirs[event], hookAppDirs[hook.Name])
+		}
+	}
+}
+
+func generateRestartScript(plan restartPlan, name string, useRootDir bool) error {
 	var scriptPath string
 	if useRootDir {
 		scriptPath = fmt.Sprintf("%s/%s/restart.sh", pkg.Settings.ManifestDir, name)
 	} else {
 		scriptPath = fmt.Sprintf("%s/%s/restart.sh", pkg.Settings.ManifestDir, name)
 	}
+	composeDirs := plan.composeDirs
+	releaseDir := fmt.Sprintf("%s/%s", pkg.Settings.ManifestDir, name)
 	
+	containerCommand, composeCommand := engineCommands(plan.engine)
 	script := `#!/bin/bash
 set -e
 
-echo "Restarting all services..."
+engine=` + containerCommand + `
+compose=` + composeCommand + `
+
+# The first successful run installs the release, later runs upgrade it
+phase=install
+if [ -f "` + releaseDir + `/.installed" ]; then
+    phase=upgrade
+fi
+
+echo "Restarting all services ($phase)..."
 
-# Function to restart a single compose service
-restart_service() {
+# Function to stop a single compose service
+stop_service() {
     local dir=$1
     local service_name=$(basename "$dir")
     
     echo "Stopping $service_name..."
     cd "$dir"
-    docker-compose down
+    # services renamed or dropped since the last sync are orphans of the project
+    $compose down --remove-orphans
+}
+
+# Function to start a single compose service
+start_service() {
+    local dir=$1
+    local service_name=$(basename "$dir")
     
     echo "Starting $service_name..."
-    docker-compose up -d
+    cd "$dir"
+    $compose up -d
     
     echo "$service_name restarted successfully"
     echo "---"
 }
 
-# Restart each service
+# Function to tear down a project of an earlier sync once, its compose file is set aside afterwards
+stop_legacy_project() {
+    local dir=$1
+
+    if [ -f "$dir/docker-compose.yaml" ]; then
+        echo "Stopping $(basename "$dir") of an earlier sync..."
+        (cd "$dir" && $compose down --remove-orphans)
+        mv "$dir/docker-compose.yaml" "$dir/docker-compose.yaml.legacy"
+    fi
+}
+
+# Function to run a pod with podman kube play, replacing the one played by the last run
+play_pod() {
+    local file=$1
+    local network=$2
+
+    echo "Starting pod $(basename "$file" .yaml)..."
+    $engine network exists "${network%%:*}" || $engine network create "${network%%:*}" >/dev/null
+    $engine kube play --replace --network "$network" "$file"
+}
+
+# Function to run a Job in the background, unless it already completed with the same spec
+run_job() {
+    local dir=$1
+    local job_name=$(basename "$dir")
+    
+    cd "$dir"
+    if sha256sum -c --status .job-completed 2>/dev/null; then
+        echo "$job_name already completed, skipping"
+        return
+    fi
+    echo "Running $job_name in the background, logs in $dir/job.log"
+    nohup ./run-job.sh > job.log 2>&1 &
+}
+
+# Function to run a Helm hook to completion, aborting the rollout if it fails
+run_hook() {
+    local dir=$1
+    
+    echo "Running hook $(basename "$dir")..."
+    (cd "$dir" && ./run-job.sh)
+}
+`
+	script += hookScript(plan, "pre")
+
+	if len(plan.legacyDirs) > 0 {
+		script += "\n# Projects of earlier syncs would hold on to the ports and names of the release\n"
+		for _, dir := range plan.legacyDirs {
+			script += fmt.Sprintf("stop_legacy_project \"%s\"\n", dir)
+		}
+	}
+
+	script += `
+# Stop every service first, so pods are recreated as a whole
 `
 
 	for _, dir := range composeDirs {
-		script += fmt.Sprintf("restart_service \"%s\"\n", dir)
+		script += fmt.Sprintf("stop_service \"%s\"\n", dir)
 	}
+	if plan.engine == EnginePodman {
+		// pods dropped from the release since the last sync go too
+		script += fmt.Sprintf(`for pod in $($engine pod ls -q --filter label=%[1]srelease=%[2]s); do
+    $engine pod rm -f "$pod" >/dev/null
+done
+`, labelPrefix, name)
+	}
+
+	script += fmt.Sprintf(`
+# emptyDir volumes live only as long as their pod, like in Kubernetes. Kept hook containers and
+# CronJob runs in progress may still use some, those are left for the next restart
+echo "Removing emptyDir volumes..."
+for volume in $($engine volume ls -q --filter label=%[1]srelease=%[2]s --filter label=%[1]sempty-dir); do
+    $engine volume rm "$volume" >/dev/null 2>&1 || echo "warning: emptyDir volume $volume is still in use, keeping it"
+done
+
+`, labelPrefix, name)
+
+	for _, dir := range composeDirs {
+		script += fmt.Sprintf("start_service \"%s\"\n", dir)
+	}
+	script += podScript(plan.pods)
 	
-	script += `
-echo "All services restarted successfully!"
+	script += hookScript(plan, "post")
+
+	if len(plan.jobDirs) > 0 {
+		script += "\n# Jobs run to completion once the services are up\n"
+	}
+	for _, dir := range plan.jobDirs {
+		script += fmt.Sprintf("run_job \"%s\"\n", dir)
+	}
+
+	// the release's CronJobs own one block of the user's crontab, replaced on every restart
+	script += fmt.Sprintf(`
+if command -v crontab >/dev/null 2>&1; then
+    echo "Installing CronJob schedules..."
+    {
+        crontab -l 2>/dev/null | sed '/^# BEGIN compose %[1]s$/,/^# END compose %[1]s$/d'
+`, name)
+	if len(plan.cronFragments) > 0 {
+		script += fmt.Sprintf("        echo \"# BEGIN compose %s\"\n", name)
+		for _, fragment := range plan.cronFragments {
+			script += fmt.Sprintf("        cat \"%s\"\n", fragment)
+		}
+		script += fmt.Sprintf("        echo \"# END compose %s\"\n", name)
+	}
+	script += `    } | crontab -
+fi
+`
 
+	script += fmt.Sprintf(`
+touch "%s/.installed"
+echo "All services restarted successfully!"
+`, releaseDir)
+	script += `
 # Optional: Show status of all services
 echo ""
 echo "Service status:"
@@ -121,9 +656,12 @@ echo "Service status:"
 
 	for _, dir := range composeDirs {
 		script += fmt.Sprintf("echo \"Status for %s:\"\n", dir)
-		script += fmt.Sprintf("cd \"%s\" && docker-compose ps\n", dir)
+		script += fmt.Sprintf("cd \"%s\" && $compose ps\n", dir)
 		script += "echo \"\"\n"
 	}
+	if len(plan.pods) > 0 {
+		script += fmt.Sprintf("$engine pod ps --filter label=%srelease=%s\n", labelPrefix, name)
+	}
 
 	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
 		return fmt.Errorf("error writing restart script: %v", err)
@@ -132,3 +670,71 @@ echo "Service status:"
 	fmt.Printf("Restart script written to %s\n", scriptPath)
 	return nil
 }
+
+// podScript renders the calls playing the Podman pods of a release, once the compose project created the
+// release network. Their named volumes are created first, with the labels and options compose would give them
+func podScript(pods []podmanPod) string {
+	if len(pods) == 0 {
+		return ""
+	}
+	script := "\n# Pods run as Podman pods, sharing their namespaces through an infra container\n"
+	for _, pod := range pods {
+		volumeNames := make([]string, 0, len(pod.volumes))
+		for volumeName := range pod.volumes {
+			volumeNames = append(volumeNames, volumeName)
+		}
+		sort.Strings(volumeNames)
+		for _, volumeName := range volumeNames {
+			volume := pod.volumes[volumeName]
+			create := "$engine volume create"
+			if volume.Driver != "" {
+				create += " --driver " + shellQuote(volume.Driver)
+			}
+			for _, option := range sortedPairs(volume.DriverOpts) {
+				create += " --opt " + shellQuote(option)
+			}
+			for _, label := range sortedPairs(volume.Labels) {
+				create += " --label " + shellQuote(label)
+			}
+			script += fmt.Sprintf("$engine volume exists %[1]s || %[2]s %[1]s >/dev/null\n", shellQuote(volume.Name), create)
+		}
+		script += fmt.Sprintf("play_pod \"%s\" %s\n", pod.file, shellQuote(pod.network))
+	}
+	return script
+}
+
+// sortedPairs returns the key=value pairs of a map, sorted
+func sortedPairs(values map[string]string) []string {
+	pairs := make([]string, 0, len(values))
+	for key, value := range values {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// hookScript renders the calls running the "pre" or "post" hooks of the current phase
+func hookScript(plan restartPlan, stage string) string {
+	installDirs := plan.hookDirs[stage+"-install"]
+	upgradeDirs := plan.hookDirs[stage+"-upgrade"]
+	if len(installDirs) == 0 && len(upgradeDirs) == 0 {
+		return ""
+	}
+	script := fmt.Sprintf("\n# %s-install and %s-upgrade hooks, in weight order\n", stage, stage)
+	script += "if [ \"$phase\" = install ]; then\n"
+	for _, dir := range installDirs {
+		script += fmt.Sprintf("    run_hook \"%s\"\n", dir)
+	}
+	if len(installDirs) == 0 {
+		script += "    :\n"
+	}
+	script += "else\n"
+	for _, dir := range upgradeDirs {
+		script += fmt.Sprintf("    run_hook \"%s\"\n", dir)
+	}
+	if len(upgradeDirs) == 0 {
+		script += "    :\n"
+	}
+	script += "fi\n"
+	return script
+}
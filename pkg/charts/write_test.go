@@ -0,0 +1,31 @@
+package charts
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLegacyProjects(t *testing.T) {
+	releaseDir := filepath.Join(t.TempDir(), "rel")
+	// the release layout's project, one named after its container only, and the current one
+	for _, dir := range []string{releaseDir, releaseDir + "/nginx", releaseDir + "/rel-web-nginx", releaseDir + "/rel-web-nginx/config"} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{releaseDir, releaseDir + "/nginx", releaseDir + "/rel-web-nginx"} {
+		if err := os.WriteFile(dir+"/docker-compose.yaml", []byte("services: {}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := legacyProjects(releaseDir, []string{releaseDir + "/rel-web-nginx/"})
+	want := []string{releaseDir, releaseDir + "/nginx"}
+	if !slices.Equal(got, want) {
+		t.Errorf("legacyProjects() = %v, want %v", got, want)
+	}
+	if got := legacyProjects(releaseDir, []string{releaseDir, releaseDir + "/nginx", releaseDir + "/rel-web-nginx"}); len(got) != 0 {
+		t.Errorf("legacyProjects() = %v, want none", got)
+	}
+}
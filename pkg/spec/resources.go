@@ -3,15 +3,103 @@ package spec
 //--kubernetes respources--
 
 type App struct {
-	Name      string            `json:"name"`
-	Type      string            `json:"type"`
-	Image     string            `json:"image"`
-	Command   []string          `json:"command,omitempty"`
-	PostStart *PostStartHook    `json:"postStart,omitempty"`
-	Configs   map[string]string `json:"configs"`
-	Mounts    map[string]string `json:"mounts"` 
-	Ports     []string          `json:"ports"`
-	NetworkMode string          `json:"NetworkMode"`
+	Name                  string                         `json:"name"`
+	Type                  string                         `json:"type"`
+	Image                 string                         `json:"image"`
+	Command               []string                       `json:"command,omitempty"`
+	PostStart             *PostStartHook                 `json:"postStart,omitempty"`
+	Configs               map[string]string              `json:"configs"`
+	Mounts                map[string]string              `json:"mounts"`
+	Ports                 []string                       `json:"ports"`
+	NetworkMode           string                         `json:"NetworkMode"`
+	Pid                   string                         `json:"pid,omitempty"`
+	Ipc                   string                         `json:"ipc,omitempty"`
+	Pod                   string                         `json:"pod,omitempty"` // name of the Kubernetes pod the container runs in
+	ShareProcessNamespace bool                           `json:"shareProcessNamespace,omitempty"`
+	Labels                map[string]string              `json:"labels,omitempty"`
+	Replicas              *int                           `json:"replicas,omitempty"`
+	Hostname              string                         `json:"hostname,omitempty"`
+	Domainname            string                         `json:"domainname,omitempty"`
+	Aliases               []string                       `json:"aliases,omitempty"`    // extra DNS names on the release network
+	ExtraHosts            []string                       `json:"extraHosts,omitempty"` // host:address entries of the hosts file
+	Endpoints             map[string][]int               `json:"endpoints,omitempty"`  // Service name -> container port of each Service port, 0 if unresolved
+	Volumes               []AppVolume                    `json:"volumes,omitempty"`
+	Binds                 []AppBind                      `json:"binds,omitempty"`
+	Init                  bool                           `json:"init,omitempty"`
+	Restart               string                         `json:"restart,omitempty"`
+	DependsOn             map[string]string              `json:"dependsOn,omitempty"` // service name -> compose depends_on condition
+	Healthcheck           *AppHealthcheck                `json:"healthcheck,omitempty"`
+	Resources             *AppResources                  `json:"resources,omitempty"`
+	Security              *AppSecurity                   `json:"security,omitempty"`
+	Job                   *JobSpec                       `json:"job,omitempty"`
+	Hook                  *HookSpec                      `json:"hook,omitempty"`
+	Compose               map[string]interface{}         `json:"compose,omitempty"` // rendered by an external converter, written as is
+	ComposeVolumes        map[string]DockerComposeVolume `json:"composeVolumes,omitempty"`
+}
+
+// AppSecurity is the merged pod and container securityContext of a container
+type AppSecurity struct {
+	User            string   `json:"user,omitempty"`
+	GroupAdd        []string `json:"groupAdd,omitempty"`
+	ReadOnly        bool     `json:"readOnly,omitempty"`
+	Privileged      bool     `json:"privileged,omitempty"`
+	NoNewPrivileges bool     `json:"noNewPrivileges,omitempty"`
+	CapAdd          []string `json:"capAdd,omitempty"`
+	CapDrop         []string `json:"capDrop,omitempty"`
+}
+
+// AppResources are the cpu, memory and GPU requests and limits of a container, cpus in cores and memory in bytes
+type AppResources struct {
+	CPULimit      float64 `json:"cpuLimit,omitempty"`
+	CPURequest    float64 `json:"cpuRequest,omitempty"`
+	MemoryLimit   int64   `json:"memoryLimit,omitempty"`
+	MemoryRequest int64   `json:"memoryRequest,omitempty"`
+	GPUs          int     `json:"gpus,omitempty"`
+}
+
+// AppHealthcheck is the healthcheck translated from a container's probes, durations in seconds
+type AppHealthcheck struct {
+	Test        []string `json:"test"`
+	Interval    int      `json:"interval"`
+	Timeout     int      `json:"timeout"`
+	Retries     int      `json:"retries"`
+	StartPeriod int      `json:"startPeriod,omitempty"`
+	Readiness   bool     `json:"readiness,omitempty"` // translated from a readinessProbe, dependents wait for it
+}
+
+// HookSpec carries the Helm hook settings of apps rendered from hook resources
+type HookSpec struct {
+	Events         []string `json:"events"`
+	Weight         int      `json:"weight"`
+	DeletePolicies []string `json:"deletePolicies,omitempty"`
+}
+
+// JobSpec carries the run-to-completion settings of apps coming from Jobs and CronJobs
+type JobSpec struct {
+	BackoffLimit          int    `json:"backoffLimit"`
+	ActiveDeadlineSeconds int    `json:"activeDeadlineSeconds,omitempty"`
+	Schedule              string `json:"schedule,omitempty"`
+	ConcurrencyPolicy     string `json:"concurrencyPolicy,omitempty"`
+	Suspend               bool   `json:"suspend,omitempty"`
+}
+
+// AppBind is a host path bind mounted into an app
+type AppBind struct {
+	HostPath  string `json:"hostPath"`
+	MountPath string `json:"mountPath"`
+	Type      string `json:"type,omitempty"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// AppVolume is a named volume mounted into an app, backed by a compose volume
+type AppVolume struct {
+	Name       string            `json:"name"`
+	MountPath  string            `json:"mountPath"`
+	SubPath    string            `json:"subPath,omitempty"`
+	ReadOnly   bool              `json:"readOnly,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Driver     string            `json:"driver,omitempty"`
+	DriverOpts map[string]string `json:"driverOpts,omitempty"`
 }
 
 type PostStartHook struct {
@@ -19,13 +107,6 @@ type PostStartHook struct {
 	Command []string `json:"command,omitempty"`
 	HTTPGet string   `json:"httpGet,omitempty"`
 }
-type Resource struct {
-	APIVersion string                 `yaml:"apiVersion"`
-	Kind       string                 `yaml:"kind"`
-	Metadata   map[string]interface{} `yaml:"metadata"`
-	Spec       map[string]interface{} `yaml:"spec,omitempty"`
-	Data       map[string]interface{} `yaml:"data,omitempty"`
-}
 
 type EnvFrom struct {
 	ConfigMapRef *ConfigMapRef `yaml:"configMapRef,omitempty"`
@@ -88,26 +169,132 @@ type DockerComposeService struct{
 	Image string `yaml:"image"`
   Command     []string          `yaml:"command,omitempty"`
 	Environment map[string]string `yaml:"environment,omitempty"`
-	Volumes     []string          `yaml:"volumes,omitempty"`
-	Restart     string            `yaml:"restart,omitempty"`
-	Networks    []string          `yaml:"networks,omitempty"`
-	Ports       []string          `yaml:"ports"`
-	NetworkMode string `yaml:"network_mode,omitempty"`
+	Volumes        []DockerComposeServiceVolume           `yaml:"volumes,omitempty"`
+	Restart        string                                 `yaml:"restart,omitempty"`
+	Hostname       string                                 `yaml:"hostname,omitempty"`
+	Domainname     string                                 `yaml:"domainname,omitempty"`
+	Networks       map[string]DockerComposeServiceNetwork `yaml:"networks,omitempty"`
+	Ports          []string                               `yaml:"ports"`
+	NetworkMode    string                                 `yaml:"network_mode,omitempty"`
+	ExtraHosts     []string                               `yaml:"extra_hosts,omitempty"`
+	Pid            string                                 `yaml:"pid,omitempty"`
+	Ipc            string                                 `yaml:"ipc,omitempty"`
+	Labels         map[string]string                      `yaml:"labels,omitempty"`
+	DependsOn      map[string]DockerComposeDependency     `yaml:"depends_on,omitempty"`
+	Profiles       []string                               `yaml:"profiles,omitempty"`
+	Healthcheck    *DockerComposeHealthcheck              `yaml:"healthcheck,omitempty"`
+	User           string                                 `yaml:"user,omitempty"`
+	GroupAdd       []string                               `yaml:"group_add,omitempty"`
+	ReadOnly       bool                                   `yaml:"read_only,omitempty"`
+	Privileged     bool                                   `yaml:"privileged,omitempty"`
+	SecurityOpt    []string                               `yaml:"security_opt,omitempty"`
+	CapAdd         []string                               `yaml:"cap_add,omitempty"`
+	CapDrop        []string                               `yaml:"cap_drop,omitempty"`
+	MemLimit       int64                                  `yaml:"mem_limit,omitempty"`
+	MemReservation int64                                  `yaml:"mem_reservation,omitempty"`
+	Cpus           string                                 `yaml:"cpus,omitempty"`
+	Deploy         *DockerComposeDeploy                   `yaml:"deploy,omitempty"`
+	Fragment       map[string]interface{}                 `yaml:"-"` // a service rendered by an external converter, written instead of the fields above
+}
+
+// MarshalYAML writes fragment services as the converter printed them, in whatever compose syntax it used
+func (s DockerComposeService) MarshalYAML() (interface{}, error) {
+	if s.Fragment != nil {
+		return s.Fragment, nil
+	}
+	type service DockerComposeService
+	return service(s), nil
+}
+
+type DockerComposeHealthcheck struct {
+	Test        []string `yaml:"test"`
+	Interval    string   `yaml:"interval,omitempty"`
+	Timeout     string   `yaml:"timeout,omitempty"`
+	Retries     int      `yaml:"retries,omitempty"`
+	StartPeriod string   `yaml:"start_period,omitempty"`
+}
+
+type DockerComposeServiceNetwork struct {
+	Aliases []string `yaml:"aliases,omitempty"`
+}
+
+type DockerComposeDeploy struct {
+	Replicas  *int                    `yaml:"replicas,omitempty"`
+	Resources *DockerComposeResources `yaml:"resources,omitempty"`
+}
+
+type DockerComposeResources struct {
+	Limits       *DockerComposeResourceSpec `yaml:"limits,omitempty"`
+	Reservations *DockerComposeResourceSpec `yaml:"reservations,omitempty"`
+}
+
+type DockerComposeResourceSpec struct {
+	Cpus    string                `yaml:"cpus,omitempty"`
+	Memory  int64                 `yaml:"memory,omitempty"`
+	Devices []DockerComposeDevice `yaml:"devices,omitempty"`
+}
+
+type DockerComposeDevice struct {
+	Driver       string   `yaml:"driver,omitempty"`
+	Count        int      `yaml:"count,omitempty"`
+	Capabilities []string `yaml:"capabilities"`
+}
+
+type DockerComposeDependency struct {
+	Condition string `yaml:"condition"`
+}
+
+type DockerComposeServiceVolume struct {
+	Type     string                      `yaml:"type"`
+	Source   string                      `yaml:"source,omitempty"`
+	Target   string                      `yaml:"target"`
+	ReadOnly bool                        `yaml:"read_only,omitempty"`
+	Volume   *DockerComposeVolumeOptions `yaml:"volume,omitempty"`
+	Bind     *DockerComposeBindOptions   `yaml:"bind,omitempty"`
+}
+
+type DockerComposeBindOptions struct {
+	CreateHostPath bool `yaml:"create_host_path,omitempty"`
+}
+
+type DockerComposeVolumeOptions struct {
+	Subpath string `yaml:"subpath,omitempty"`
+}
+
+type DockerComposeVolume struct {
+	Name       string            `yaml:"name,omitempty"`
+	Driver     string            `yaml:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"`
+	Labels     map[string]string `yaml:"labels,omitempty"`
+}
+
+// ComposeFragment is what an external converter prints, the services and named volumes of a resource
+type ComposeFragment struct {
+	Services map[string]map[string]interface{} `yaml:"services"`
+	Volumes  map[string]DockerComposeVolume    `yaml:"volumes,omitempty"`
 }
 
 type DockerCompose struct{
 	Services map[string]DockerComposeService `yaml:"services"`
-	Networks map[string]interface{} `yaml:"networks"`
+	Networks map[string]interface{}          `yaml:"networks"`
+	Volumes  map[string]DockerComposeVolume  `yaml:"volumes,omitempty"`
 }
 
 //--additional--
 type ServiceInfo struct {
     Name     string             `json:"name"`
+	Namespace string            `json:"namespace"`
+	Type      string            `json:"type"`                // ClusterIP, NodePort, LoadBalancer or ExternalName
+	ClusterIP string            `json:"clusterIP,omitempty"` // None for headless Services
     Ports    []PortInfo         `json:"ports"`
     Selector map[string]string  `json:"selector"`
 }
 
 type PortInfo struct {
+	Name           string `json:"name,omitempty"`
     Port       int    `json:"port"`
+	TargetPort     int    `json:"targetPort,omitempty"`
+	TargetPortName string `json:"targetPortName,omitempty"` // named containerPort, resolved per container
+	NodePort       int    `json:"nodePort,omitempty"`
     Protocol   string `json:"protocol"`
 }